@@ -0,0 +1,85 @@
+package accessLog
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UpYun cdn 访问日志格式:
+// $remote_addr - - [$time_local] "$host" "$request" $status $body_bytes_sent $request_time "$http_referer" "$http_user_agent" ...
+var lineRegexp = regexp.MustCompile(`^(\S+) \S+ \S+ \[([^\]]+)\] "([^"]*)" "([^"]*)" (\d{3}) (\d+|-) ([\d.]+|-) "([^"]*)" "([^"]*)"`)
+
+const timeLayout = "02/Jan/2006:15:04:05 -0700"
+
+type Entry struct {
+	RemoteAddr  string
+	Time        time.Time
+	Host        string
+	Method      string
+	Path        string
+	Status      string
+	BodyBytes   float64
+	RequestTime float64
+	Referer     string
+	UserAgent   string
+}
+
+// ParseLine 解析一行访问日志
+func ParseLine(line string) (Entry, error) {
+	var entry Entry
+	match := lineRegexp.FindStringSubmatch(line)
+	if match == nil {
+		return entry, errors.New("line does not match upyun access log format")
+	}
+	entry.RemoteAddr = match[1]
+	t, err := time.Parse(timeLayout, match[2])
+	if err != nil {
+		return entry, err
+	}
+	entry.Time = t
+	entry.Host = match[3]
+	// $request 形如 GET /path?query HTTP/1.1
+	request := strings.SplitN(match[4], " ", 3)
+	if len(request) < 2 {
+		return entry, errors.New("invalid request field: " + match[4])
+	}
+	entry.Method = request[0]
+	entry.Path = request[1]
+	entry.Status = match[5]
+	if match[6] != "-" {
+		entry.BodyBytes, _ = strconv.ParseFloat(match[6], 64)
+	}
+	if match[7] != "-" {
+		entry.RequestTime, _ = strconv.ParseFloat(match[7], 64)
+	}
+	entry.Referer = match[8]
+	entry.UserAgent = match[9]
+	return entry, nil
+}
+
+// NormalizePath 去掉 query string, 文件名统一折叠为 *, 只保留前 depth 级目录, 控制 path label 的基数,
+// 如 depth 为 2 时 /img/a/b/<hash>.png 归为 /img/a/*; 以 / 结尾的目录路径在层级内原样保留;
+// depth 为 0 时不做截断与折叠
+func NormalizePath(path string, depth int) string {
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	if depth <= 0 {
+		return path
+	}
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	dirs, file := segments[:len(segments)-1], segments[len(segments)-1]
+	if file == "" && len(dirs) <= depth {
+		return path
+	}
+	if len(dirs) > depth {
+		dirs = dirs[:depth]
+	}
+	if len(dirs) == 0 {
+		return "/*"
+	}
+	return "/" + strings.Join(dirs, "/") + "/*"
+}
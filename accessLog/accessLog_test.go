@@ -0,0 +1,106 @@
+package accessLog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    Entry
+		wantErr bool
+	}{
+		{
+			name: "full line",
+			line: `1.2.3.4 - - [02/Jan/2024:15:04:05 +0800] "cdn.example.com" "GET /img/a.png?w=100 HTTP/1.1" 200 1024 0.125 "https://example.com/" "curl/8.0" "-"`,
+			want: Entry{
+				RemoteAddr:  "1.2.3.4",
+				Time:        time.Date(2024, 1, 2, 7, 4, 5, 0, time.UTC),
+				Host:        "cdn.example.com",
+				Method:      "GET",
+				Path:        "/img/a.png?w=100",
+				Status:      "200",
+				BodyBytes:   1024,
+				RequestTime: 0.125,
+				Referer:     "https://example.com/",
+				UserAgent:   "curl/8.0",
+			},
+		},
+		{
+			name: "missing size and request time",
+			line: `1.2.3.4 - - [02/Jan/2024:15:04:05 +0800] "cdn.example.com" "HEAD / HTTP/1.1" 304 - - "-" "-"`,
+			want: Entry{
+				RemoteAddr: "1.2.3.4",
+				Time:       time.Date(2024, 1, 2, 7, 4, 5, 0, time.UTC),
+				Host:       "cdn.example.com",
+				Method:     "HEAD",
+				Path:       "/",
+				Status:     "304",
+				Referer:    "-",
+				UserAgent:  "-",
+			},
+		},
+		{
+			name:    "not an access log",
+			line:    "hello world",
+			wantErr: true,
+		},
+		{
+			name:    "invalid time",
+			line:    `1.2.3.4 - - [2024-01-02 15:04:05] "cdn.example.com" "GET / HTTP/1.1" 200 1 0.1 "-" "-"`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid request",
+			line:    `1.2.3.4 - - [02/Jan/2024:15:04:05 +0800] "cdn.example.com" "GET" 400 0 0.001 "-" "-"`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLine(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLine() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !got.Time.Equal(tt.want.Time) {
+				t.Errorf("ParseLine() time = %v, want %v", got.Time, tt.want.Time)
+			}
+			got.Time = tt.want.Time
+			if got != tt.want {
+				t.Errorf("ParseLine() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		path  string
+		depth int
+		want  string
+	}{
+		{"/a/b/c.png", 0, "/a/b/c.png"},
+		{"/a/b/c.png?x=1", 0, "/a/b/c.png"},
+		{"/a/b/c.png#top", 5, "/a/b/*"},
+		{"/a/b/c.png", 1, "/a/*"},
+		{"/a/b/c.png", 2, "/a/b/*"},
+		{"/a/b/c.png", 3, "/a/b/*"},
+		{"/a/b/c.png?x=/d/e", 1, "/a/*"},
+		{"/img/0f3a9c.png", 2, "/img/*"},
+		{"/favicon.ico", 2, "/*"},
+		{"/a/b/", 2, "/a/b/"},
+		{"/a/b/c/", 2, "/a/b/*"},
+		{"/", 1, "/"},
+		{"/", 0, "/"},
+	}
+	for _, tt := range tests {
+		if got := NormalizePath(tt.path, tt.depth); got != tt.want {
+			t.Errorf("NormalizePath(%q, %d) = %q, want %q", tt.path, tt.depth, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"upyun-exporter/exporter"
+)
+
+const apiDomainsPath = "/api/v1/domains"
+
+// domainStatus 返回域名最近一次的采集状态, 尚未采集过的域名只有域名和空间名
+func domainStatus(store *exporter.SnapshotStore, domain string) exporter.DomainStatus {
+	status, ok := store.Domain(domain)
+	if !ok {
+		status.Domain = domain
+	}
+	status.Bucket = DomainBucket(domain)
+	return status
+}
+
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	// 先编码到缓冲区, 编码失败时返回 500 而不是截断的响应
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(value); err != nil {
+		http.Error(w, "failed to encode response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// registerAPI 注册 /api/v1/domains 及 /api/v1/domains/{domain},
+// 以 JSON 返回各域名最近一次 cdn 指标采集的结果
+func registerAPI(store *exporter.SnapshotStore) {
+	http.HandleFunc(apiDomainsPath, func(w http.ResponseWriter, r *http.Request) {
+		domainList := DomainList()
+		domains := make([]exporter.DomainStatus, 0, len(domainList))
+		for _, domain := range domainList {
+			domains = append(domains, domainStatus(store, domain))
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"domains": domains})
+	})
+	http.HandleFunc(apiDomainsPath+"/", func(w http.ResponseWriter, r *http.Request) {
+		domain := strings.TrimPrefix(r.URL.Path, apiDomainsPath+"/")
+		for _, known := range DomainList() {
+			if known == domain {
+				writeJSON(w, http.StatusOK, domainStatus(store, domain))
+				return
+			}
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown domain " + domain})
+	})
+}
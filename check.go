@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"upyun-exporter/httpRequest"
+	"upyun-exporter/logging"
+)
+
+// checkHint 根据接口返回的状态码给出排查建议
+func checkHint(result httpRequest.CheckResult) string {
+	if result.Err == nil {
+		return "ok"
+	}
+	switch result.StatusCode {
+	case 0:
+		return result.Err.Message
+	case 401:
+		return "token is invalid or expired"
+	case 403:
+		return "token lacks permission for this endpoint"
+	case 404:
+		return "bucket or domain not found"
+	case 429:
+		return "rate limited by UpYun, retry later"
+	default:
+		return "unexpected response, run with --log.level=debug for details"
+	}
+}
+
+// runCheck 检查 token 能否访问 exporter 用到的每个接口, 有接口失败时以非 0 状态退出
+func runCheck(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	credentials := registerCredentialFlags(fs)
+	logConfig := registerLogFlags(fs)
+	registerTimeZoneFlag(fs)
+	bucket := fs.String("bucket", "", "检查buckets/info使用的空间名, 默认取空间列表中的第一个")
+	domain := fs.String("domain", "", "检查统计接口使用的域名, 默认取空间列表中的第一个域名")
+	collectors := registerCollectorFlags(fs)
+	_ = fs.Parse(args)
+	logging.Init(logConfig)
+	token, bucketToken := credentials.load()
+
+	failed := false
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ENDPOINT\tCOLLECTOR\tRESULT\tSTATUS\tHINT")
+	for _, result := range httpRequest.CheckEndpoints(token, bucketToken, *bucket, *domain, collectors.isEnabled) {
+		status := "-"
+		if result.StatusCode != 0 {
+			status = fmt.Sprint(result.StatusCode)
+		}
+		outcome := "PASS"
+		if result.Skipped {
+			outcome = "SKIP"
+		} else if result.Err != nil {
+			outcome = "FAIL"
+			failed = true
+		}
+		collector := result.Collector
+		if collector == "" {
+			collector = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", result.Endpoint, collector, outcome, status, logging.Redact(checkHint(result)))
+	}
+	_ = w.Flush()
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"upyun-exporter/exporter"
+)
+
+// collectorFlags 每个 cdn collector 对应 --collector.<name> 及 --no-collector.<name> 两个参数
+type collectorFlags struct {
+	enabled  map[string]*bool
+	disabled map[string]*bool
+}
+
+func registerCollectorFlags(fs *flag.FlagSet) *collectorFlags {
+	c := &collectorFlags{
+		enabled:  make(map[string]*bool),
+		disabled: make(map[string]*bool),
+	}
+	for _, name := range exporter.CollectorNames() {
+		c.enabled[name] = fs.Bool("collector."+name, exporter.CollectorDefaultEnabled(name), "启用"+name+" collector")
+		c.disabled[name] = fs.Bool("no-collector."+name, false, "禁用"+name+" collector")
+	}
+	return c
+}
+
+// isEnabled 返回 collector 是否启用, --no-collector.<name> 优先
+func (c *collectorFlags) isEnabled(name string) bool {
+	return *c.enabled[name] && !*c.disabled[name]
+}
+
+// names 返回启用的 collector
+func (c *collectorFlags) names() []string {
+	names := []string{}
+	for _, name := range exporter.CollectorNames() {
+		if c.isEnabled(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
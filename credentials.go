@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/promlog"
+	"os"
+	"sync"
+	"time"
+	"upyun-exporter/httpRequest"
+	"upyun-exporter/logging"
+)
+
+var (
+	tokenReloadTimestamp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "upyun_exporter",
+			Name:      "token_last_reload_timestamp_seconds",
+			Help:      "最近一次从文件加载token的时间(unix时间戳, 秒)",
+		},
+		[]string{"token"},
+	)
+	tokenReloadFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "upyun_exporter",
+			Name:      "token_reload_failures_total",
+			Help:      "从文件加载token失败的次数",
+		},
+		[]string{"token"},
+	)
+)
+
+// credentialFlags 服务及各子命令共用的 token 相关参数
+type credentialFlags struct {
+	bucketToken        *string
+	token              *string
+	bucketTokenFile    *string
+	tokenFile          *string
+	tokenFileInterval  *time.Duration
+	oauthUsername      *string
+	oauthPassword      *string
+	oauthClientId      *string
+	oauthClientSecret  *string
+	oauthTokenLifetime *time.Duration
+	oauthRefreshMargin *time.Duration
+}
+
+func registerCredentialFlags(fs *flag.FlagSet) *credentialFlags {
+	return &credentialFlags{
+		bucketToken:        fs.String("bucket_token", os.Getenv("UpYun_Bucket_Token"), "upYun bucket token"),
+		token:              fs.String("token", os.Getenv("UpYun_Token"), "upYun token"),
+		bucketTokenFile:    fs.String("bucket_token.file", "", "从文件读取upYun bucket token, 文件变化时自动重新加载, 优先于bucket_token"),
+		tokenFile:          fs.String("token.file", "", "从文件读取upYun token, 文件变化时自动重新加载, 优先于token"),
+		tokenFileInterval:  fs.Duration("token.file.interval", 30*time.Second, "检查token文件变化的间隔时间"),
+		oauthUsername:      fs.String("oauth.username", os.Getenv("UpYun_Username"), "用于自动申请token的操作员账号, 设置后忽略token及bucket_token"),
+		oauthPassword:      fs.String("oauth.password", os.Getenv("UpYun_Password"), "用于自动申请token的操作员密码"),
+		oauthClientId:      fs.String("oauth.client_id", os.Getenv("UpYun_Client_Id"), "用于自动申请token的client id, 设置后忽略token及bucket_token"),
+		oauthClientSecret:  fs.String("oauth.client_secret", os.Getenv("UpYun_Client_Secret"), "用于自动申请token的client secret"),
+		oauthTokenLifetime: fs.Duration("oauth.token_lifetime", 24*time.Hour, "自动申请的token有效期"),
+		oauthRefreshMargin: fs.Duration("oauth.refresh_margin", time.Hour, "在token过期前多久刷新"),
+	}
+}
+
+func registerLogFlags(fs *flag.FlagSet) *promlog.Config {
+	logConfig := &promlog.Config{Level: &promlog.AllowedLevel{}, Format: &promlog.AllowedFormat{}}
+	_ = logConfig.Level.Set("info")
+	_ = logConfig.Format.Set("logfmt")
+	fs.Var(logConfig.Level, "log.level", "日志级别, 可选 debug, info, warn, error")
+	fs.Var(logConfig.Format, "log.format", "日志格式, 可选 logfmt, json")
+	return logConfig
+}
+
+// timeZoneFlag 解析参数时即设置 httpRequest 使用的时区, 无效的时区名会作为参数错误报告
+type timeZoneFlag struct{}
+
+func (timeZoneFlag) String() string {
+	return httpRequest.TimeZone().String()
+}
+
+func (timeZoneFlag) Set(name string) error {
+	return httpRequest.SetTimeZone(name)
+}
+
+func registerTimeZoneFlag(fs *flag.FlagSet) {
+	fs.Var(timeZoneFlag{}, "timezone", "UpYun接口请求参数中时间使用的时区(IANA时区名), 默认"+httpRequest.DefaultTimeZone)
+}
+
+func (c *credentialFlags) oauthEnabled() bool {
+	return *c.oauthUsername != "" || *c.oauthClientId != ""
+}
+
+// load 返回统计接口和空间接口使用的 token, 需在 logging.Init 之后调用
+func (c *credentialFlags) load() (*httpRequest.Token, *httpRequest.Token) {
+	logging.AddSecret(*c.token)
+	logging.AddSecret(*c.bucketToken)
+	logging.AddSecret(*c.oauthPassword)
+	logging.AddSecret(*c.oauthClientSecret)
+	if !c.oauthEnabled() {
+		return loadToken("token", *c.token, *c.tokenFile), loadToken("bucket_token", *c.bucketToken, *c.bucketTokenFile)
+	}
+
+	if *c.oauthRefreshMargin <= 0 || *c.oauthRefreshMargin >= *c.oauthTokenLifetime {
+		level.Error(logging.Logger).Log("msg", "oauth.refresh_margin must be positive and less than oauth.token_lifetime")
+		os.Exit(1)
+	}
+	// 操作员 token 同时用于统计接口和空间接口
+	token, err := httpRequest.NewRefreshingToken(httpRequest.OAuthRefresher(httpRequest.OAuthCredentials{
+		Username:     *c.oauthUsername,
+		Password:     *c.oauthPassword,
+		ClientId:     *c.oauthClientId,
+		ClientSecret: *c.oauthClientSecret,
+		Name:         "upyun-exporter",
+		Lifetime:     *c.oauthTokenLifetime,
+	}))
+	if err != nil {
+		level.Error(logging.Logger).Log("msg", "Failed to get oauth token", "err", err)
+		os.Exit(1)
+	}
+	tokenReloadTimestamp.WithLabelValues("oauth").SetToCurrentTime()
+	return token, token
+}
+
+// watch 在后台刷新 oauth token 或监听 token 文件, 直到 done 关闭
+func (c *credentialFlags) watch(token *httpRequest.Token, bucketToken *httpRequest.Token, done <-chan bool, background *sync.WaitGroup) {
+	if c.oauthEnabled() {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			httpRequest.KeepTokenFresh(token, *c.oauthRefreshMargin, done, func() {
+				tokenReloadTimestamp.WithLabelValues("oauth").SetToCurrentTime()
+				level.Info(logging.Logger).Log("msg", "Refreshed oauth token", "expires_at", token.ExpiresAt())
+			}, func(err error) {
+				tokenReloadFailures.WithLabelValues("oauth").Inc()
+				level.Warn(logging.Logger).Log("msg", "Failed to refresh oauth token", "err", err)
+			})
+		}()
+		return
+	}
+	if *c.tokenFile != "" {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			WatchToken("token", token, *c.tokenFile, *c.tokenFileInterval, done)
+		}()
+	}
+	if *c.bucketTokenFile != "" {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			WatchToken("bucket_token", bucketToken, *c.bucketTokenFile, *c.tokenFileInterval, done)
+		}()
+	}
+}
+
+// loadToken token 文件不为空时从文件读取 token, 否则使用命令行参数或环境变量中的值
+func loadToken(name string, value string, file string) *httpRequest.Token {
+	if file == "" {
+		return httpRequest.NewToken(value)
+	}
+	value, err := httpRequest.ReadTokenFile(file)
+	if err != nil {
+		level.Error(logging.Logger).Log("msg", "Failed to read token file", "token", name, "file", file, "err", err)
+		os.Exit(1)
+	}
+	logging.AddSecret(value)
+	tokenReloadTimestamp.WithLabelValues(name).SetToCurrentTime()
+	return httpRequest.NewToken(value)
+}
+
+// WatchToken 监听 token 文件变化, 直到 done 关闭
+func WatchToken(name string, token *httpRequest.Token, file string, interval time.Duration, done <-chan bool) {
+	httpRequest.WatchTokenFile(token, file, interval, done, func(value string) {
+		logging.AddSecret(value)
+		tokenReloadTimestamp.WithLabelValues(name).SetToCurrentTime()
+		level.Info(logging.Logger).Log("msg", "Reloaded token from file", "token", name, "file", file)
+	}, func(err error) {
+		tokenReloadFailures.WithLabelValues(name).Inc()
+		level.Warn(logging.Logger).Log("msg", "Failed to reload token from file", "token", name, "file", file, "err", err)
+	})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"io"
+	"os"
+	"strconv"
+	"time"
+	"upyun-exporter/httpRequest"
+	"upyun-exporter/logging"
+)
+
+// dailyStats 单个域名一天的流量汇总
+type dailyStats struct {
+	Domain   string
+	Bucket   string
+	Date     string
+	Bytes    int64
+	Requests int64
+	// 当天峰值带宽(bps)
+	PeakBandwidth   float64
+	BackSourceBytes int64
+}
+
+var dailyStatsHeader = []string{"domain", "bucket", "date", "bytes", "requests", "peak_bandwidth_bps", "backsource_bytes"}
+
+// fetchDailyStats 按天请求 v2/statistics, 汇总 [from, to] 每天的 cdn 及回源数据
+func fetchDailyStats(token *httpRequest.Token, domain string, bucket string, from time.Time, to time.Time) ([]dailyStats, error) {
+	var rows []dailyStats
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		end := day.AddDate(0, 0, 1).Add(-time.Second)
+		cdnData, err := httpRequest.DoHttpStatisticsRequest(domain, token, day, end, "cdn")
+		if err != nil {
+			return nil, err
+		}
+		backSourceData, err := httpRequest.DoHttpStatisticsRequest(domain, token, day, end, "backsource")
+		if err != nil {
+			return nil, err
+		}
+		row := dailyStats{Domain: domain, Bucket: bucket, Date: day.Format("2006-01-02")}
+		for _, point := range cdnData.Data {
+			row.Bytes += int64(point.Bytes)
+			row.Requests += int64(point.Reqs)
+			if point.Bandwidth > row.PeakBandwidth {
+				row.PeakBandwidth = point.Bandwidth
+			}
+		}
+		for _, point := range backSourceData.Data {
+			row.BackSourceBytes += int64(point.Bytes)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func writeDailyStatsCSV(w io.Writer, rows []dailyStats) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(dailyStatsHeader); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		err := writer.Write([]string{
+			row.Domain,
+			row.Bucket,
+			row.Date,
+			strconv.FormatInt(row.Bytes, 10),
+			strconv.FormatInt(row.Requests, 10),
+			strconv.FormatFloat(row.PeakBandwidth, 'f', -1, 64),
+			strconv.FormatInt(row.BackSourceBytes, 10),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// runExport 以 CSV 导出 [from, to] 每天每个域名的流量汇总, 用于按月统计账单
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	credentials := registerCredentialFlags(fs)
+	domain := fs.String("domain", "", "导出的域名, 默认导出所有空间的所有域名")
+	from := fs.String("from", "", "开始日期(包含), 格式 2006-01-02")
+	to := fs.String("to", "", "结束日期(包含), 格式 2006-01-02, 默认为from")
+	output := fs.String("output", "", "输出文件, 默认输出到stdout")
+	logConfig := registerLogFlags(fs)
+	registerTimeZoneFlag(fs)
+	_ = fs.Parse(args)
+	logging.Init(logConfig)
+
+	fromDate, err := time.ParseInLocation("2006-01-02", *from, httpRequest.TimeZone())
+	if err != nil {
+		exitWithError("invalid --from: " + err.Error())
+	}
+	toDate := fromDate
+	if *to != "" {
+		toDate, err = time.ParseInLocation("2006-01-02", *to, httpRequest.TimeZone())
+		if err != nil {
+			exitWithError("invalid --to: " + err.Error())
+		}
+	}
+	if toDate.Before(fromDate) {
+		exitWithError("--from must not be after --to")
+	}
+
+	token, bucketToken := credentials.load()
+	buckets, apiErr := httpRequest.DoBucketDomainListRequest(bucketToken)
+	if apiErr != nil {
+		exitWithError("failed to get domain list: " + apiErr.Error())
+	}
+	var rows []dailyStats
+	for _, bucket := range buckets {
+		for _, d := range bucket.Domains {
+			if *domain != "" && d != *domain {
+				continue
+			}
+			domainRows, err := fetchDailyStats(token, d, bucket.BucketName, fromDate, toDate)
+			if err != nil {
+				exitWithError("failed to get statistics of " + d + ": " + err.Error())
+			}
+			rows = append(rows, domainRows...)
+		}
+	}
+
+	if *domain != "" && len(rows) == 0 {
+		exitWithError("domain " + *domain + " not found")
+	}
+
+	w := os.Stdout
+	if *output != "" {
+		w, err = os.Create(*output)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+	}
+	if err := writeDailyStatsCSV(w, rows); err != nil {
+		exitWithError("failed to write csv: " + err.Error())
+	}
+	if *output != "" {
+		if err := w.Close(); err != nil {
+			exitWithError(err.Error())
+		}
+	}
+}
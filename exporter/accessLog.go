@@ -0,0 +1,257 @@
+package exporter
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"upyun-exporter/accessLog"
+	"upyun-exporter/httpRequest"
+	"upyun-exporter/logging"
+)
+
+// 已处理的日志文件超过该时间没有再出现在列表中时才从已处理列表中删除,
+// 远程日志只查询今天和昨天, 因此两天后不会再被列出
+const accessLogProcessedRetention = 48 * time.Hour
+
+// AccessLogExporter 下载(或从本地目录读取)访问日志并解析为延迟/大小分布及按路径的状态码计数,
+// 每个日志文件只处理一次
+type AccessLogExporter struct {
+	domainList func() []string
+	token      *httpRequest.Token
+	logDir     string
+	pathDepth  int
+	mu         sync.Mutex
+	// 已处理的日志文件及最近一次出现在列表中的时间
+	processed map[string]time.Time
+	// 最近一次 Refresh 中的错误, Refresh 期间 mu 一直被占用, 单独加锁
+	errMu           sync.Mutex
+	refreshErr      error
+	requestDuration *prometheus.HistogramVec
+	responseSize    *prometheus.HistogramVec
+	pathRequests    *prometheus.CounterVec
+	filesProcessed  *prometheus.CounterVec
+	invalidLines    *prometheus.CounterVec
+}
+
+// CdnAccessLogExporter logDir 不为空时从 logDir/<domain>/ 下读取日志文件而不是从 UpYun 下载
+func CdnAccessLogExporter(domainList func() []string, token *httpRequest.Token, logDir string, pathDepth int) *AccessLogExporter {
+	return &AccessLogExporter{
+		domainList: domainList,
+		token:      token,
+		logDir:     logDir,
+		pathDepth:  pathDepth,
+		processed:  make(map[string]time.Time),
+
+		requestDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: cdnNameSpace,
+				Subsystem: "accesslog",
+				Name:      "request_duration_seconds",
+				Help:      "访问日志请求耗时(秒)",
+				Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+			},
+			[]string{
+				"instanceId",
+			},
+		),
+		responseSize: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: cdnNameSpace,
+				Subsystem: "accesslog",
+				Name:      "response_size_bytes",
+				Help:      "访问日志响应大小(字节)",
+				Buckets:   prometheus.ExponentialBuckets(1024, 4, 10),
+			},
+			[]string{
+				"instanceId",
+			},
+		),
+		pathRequests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: cdnNameSpace,
+				Subsystem: "accesslog",
+				Name:      "requests_total",
+				Help:      "访问日志按路径及状态码统计的请求数",
+			},
+			[]string{
+				"instanceId",
+				"path",
+				"status",
+			},
+		),
+		filesProcessed: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: cdnNameSpace,
+				Subsystem: "accesslog",
+				Name:      "files_processed_total",
+				Help:      "已处理的访问日志文件数",
+			},
+			[]string{
+				"instanceId",
+			},
+		),
+		invalidLines: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: cdnNameSpace,
+				Subsystem: "accesslog",
+				Name:      "invalid_lines_total",
+				Help:      "无法解析的访问日志行数",
+			},
+			[]string{
+				"instanceId",
+			},
+		),
+	}
+}
+
+func (e *AccessLogExporter) Describe(ch chan<- *prometheus.Desc) {
+	e.requestDuration.Describe(ch)
+	e.responseSize.Describe(ch)
+	e.pathRequests.Describe(ch)
+	e.filesProcessed.Describe(ch)
+	e.invalidLines.Describe(ch)
+}
+
+// Update 输出已处理日志的统计, 返回最近一次 Refresh 中的错误
+func (e *AccessLogExporter) Update(ch chan<- prometheus.Metric) error {
+	e.requestDuration.Collect(ch)
+	e.responseSize.Collect(ch)
+	e.pathRequests.Collect(ch)
+	e.filesProcessed.Collect(ch)
+	e.invalidLines.Collect(ch)
+	e.errMu.Lock()
+	defer e.errMu.Unlock()
+	return e.refreshErr
+}
+
+// Refresh 处理所有域名尚未处理过的日志文件
+func (e *AccessLogExporter) Refresh() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	var errs []error
+	for _, domain := range e.domainList() {
+		if e.logDir != "" {
+			errs = append(errs, e.refreshLocal(domain, now)...)
+		} else {
+			errs = append(errs, e.refreshRemote(domain, now)...)
+		}
+	}
+	e.errMu.Lock()
+	e.refreshErr = errors.Join(errs...)
+	e.errMu.Unlock()
+	// 列表请求失败或域名暂时不在发现结果中时保留已处理记录, 只按时间清理, 避免重复计数
+	for key, lastSeen := range e.processed {
+		if now.Sub(lastSeen) > accessLogProcessedRetention {
+			delete(e.processed, key)
+		}
+	}
+}
+
+// seen 记录文件出现在本轮列表中, 返回是否已处理过
+func (e *AccessLogExporter) seen(key string, now time.Time) bool {
+	_, ok := e.processed[key]
+	if ok {
+		e.processed[key] = now
+	}
+	return ok
+}
+
+// refreshLocal 处理本地目录中域名的新日志文件, 返回处理中的错误
+func (e *AccessLogExporter) refreshLocal(domain string, now time.Time) []error {
+	var errs []error
+	files, err := filepath.Glob(filepath.Join(e.logDir, domain, "*"))
+	if err != nil {
+		level.Warn(logging.Logger).Log("msg", "Failed to list access log dir", "domain", domain, "err", err)
+		return []error{err}
+	}
+	for _, file := range files {
+		if e.seen(file, now) {
+			continue
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			level.Warn(logging.Logger).Log("msg", "Failed to open access log", "domain", domain, "file", file, "err", err)
+			errs = append(errs, err)
+			continue
+		}
+		e.processed[file] = now
+		err = e.process(domain, file, f)
+		f.Close()
+		if err != nil {
+			level.Warn(logging.Logger).Log("msg", "Failed to process access log", "domain", domain, "file", file, "err", err)
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// refreshRemote 下载并处理域名今天及昨天的新日志文件, 返回处理中的错误
+func (e *AccessLogExporter) refreshRemote(domain string, now time.Time) []error {
+	var errs []error
+	// 跨天时前一天最后的日志可能稍后才生成
+	for _, date := range []string{httpRequest.FormatDate(now.AddDate(0, 0, -1)), httpRequest.FormatDate(now)} {
+		logList, err := httpRequest.DoAccessLogListRequest(domain, e.token, date)
+		if err != nil {
+			level.Warn(logging.Logger).Log("msg", "Failed to get access log list", "domain", domain, "date", date, "err", err)
+			errs = append(errs, err)
+			continue
+		}
+		for _, logFile := range logList.Data {
+			key := domain + "/" + logFile.Name
+			if e.seen(key, now) {
+				continue
+			}
+			body, err := httpRequest.DownloadAccessLog(logFile.Url)
+			if err != nil {
+				level.Warn(logging.Logger).Log("msg", "Failed to download access log", "domain", domain, "file", logFile.Name, "err", err)
+				errs = append(errs, err)
+				continue
+			}
+			e.processed[key] = now
+			processErr := e.process(domain, logFile.Name, body)
+			body.Close()
+			if processErr != nil {
+				level.Warn(logging.Logger).Log("msg", "Failed to process access log", "domain", domain, "file", logFile.Name, "err", processErr)
+				errs = append(errs, processErr)
+			}
+		}
+	}
+	return errs
+}
+
+func (e *AccessLogExporter) process(domain string, name string, r io.Reader) error {
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry, err := accessLog.ParseLine(scanner.Text())
+		if err != nil {
+			e.invalidLines.WithLabelValues(domain).Inc()
+			continue
+		}
+		e.requestDuration.WithLabelValues(domain).Observe(entry.RequestTime)
+		e.responseSize.WithLabelValues(domain).Observe(entry.BodyBytes)
+		e.pathRequests.WithLabelValues(domain, accessLog.NormalizePath(entry.Path, e.pathDepth), entry.Status).Inc()
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	e.filesProcessed.WithLabelValues(domain).Inc()
+	return nil
+}
@@ -0,0 +1,125 @@
+package exporter
+
+import (
+	"errors"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"unicode/utf8"
+	"upyun-exporter/httpRequest"
+	"upyun-exporter/logging"
+)
+
+var topAnalysisTypes = []string{"url", "referer", "ip", "ua"}
+
+// TopAnalysisExporter 导出每个域名当天请求数最多的 url, referer, 客户端 ip 及 ua
+type TopAnalysisExporter struct {
+	domainList     func() []string
+	token          *httpRequest.Token
+	topN           int
+	maxLabelLength int
+	cdnTopRequests *prometheus.Desc
+	cdnTopBytes    *prometheus.Desc
+}
+
+func CdnTopAnalysisExporter(domainList func() []string, token *httpRequest.Token, topN int, maxLabelLength int) *TopAnalysisExporter {
+	return &TopAnalysisExporter{
+		domainList:     domainList,
+		token:          token,
+		topN:           topN,
+		maxLabelLength: maxLabelLength,
+
+		cdnTopRequests: prometheus.NewDesc(
+			prometheus.BuildFQName(cdnNameSpace, "cdn", "top_requests"),
+			"cdn当天top N请求数",
+			[]string{
+				"instanceId",
+				"type",
+				"value",
+			},
+			nil,
+		),
+		cdnTopBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(cdnNameSpace, "cdn", "top_bytes"),
+			"cdn当天top N流量(字节)",
+			[]string{
+				"instanceId",
+				"type",
+				"value",
+			},
+			nil,
+		),
+	}
+}
+
+// truncateLabel 按字符截断过长的 label 值, 避免 url, ua 等导致标签过长
+func truncateLabel(value string, maxLength int) string {
+	if maxLength <= 0 || utf8.RuneCountInString(value) <= maxLength {
+		return value
+	}
+	runes := []rune(value)
+	return string(runes[:maxLength]) + "..."
+}
+
+func (e *TopAnalysisExporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- e.cdnTopRequests
+	ch <- e.cdnTopBytes
+}
+
+// Update 查询所有域名的 top N 统计, 返回查询中的错误
+func (e *TopAnalysisExporter) Update(ch chan<- prometheus.Metric) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, domain := range e.domainList() {
+		for _, analysisType := range topAnalysisTypes {
+			domain := domain
+			analysisType := analysisType
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				analysis, err := httpRequest.DoTopAnalysisRequest(domain, e.token, analysisType, e.topN)
+				if err != nil {
+					level.Warn(logging.Logger).Log("msg", "Failed to get top analysis", "domain", domain, "type", analysisType, "err", err)
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+					return
+				}
+				// 截断后可能出现相同的 label 值, 需要合并
+				requests := make(map[string]float64)
+				bytes := make(map[string]float64)
+				for i, item := range analysis.Data {
+					if i >= e.topN {
+						break
+					}
+					value := truncateLabel(item.Name, e.maxLabelLength)
+					requests[value] += item.Reqs
+					bytes[value] += item.Bytes
+				}
+				for value, count := range requests {
+					ch <- prometheus.MustNewConstMetric(
+						e.cdnTopRequests,
+						prometheus.GaugeValue,
+						count,
+						domain,
+						analysisType,
+						value,
+					)
+					ch <- prometheus.MustNewConstMetric(
+						e.cdnTopBytes,
+						prometheus.GaugeValue,
+						bytes[value],
+						domain,
+						analysisType,
+						value,
+					)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,100 @@
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+)
+
+type DomainCertificate struct {
+	HttpsEnabled  bool
+	CertificateId string
+	CommonName    string
+	// 证书过期时间, unix 时间戳(秒), 未绑定证书时为 0
+	NotAfter float64
+}
+
+// CertificateExporter 导出 cdn 域名绑定证书的过期时间, 数据随域名列表一起刷新
+type CertificateExporter struct {
+	mu           sync.RWMutex
+	certificates map[string]DomainCertificate
+	// 最近一次刷新中的错误
+	err             error
+	cdnCertNotAfter *prometheus.Desc
+	cdnHttpsEnabled *prometheus.Desc
+}
+
+func CdnCertificateExporter() *CertificateExporter {
+	return &CertificateExporter{
+		certificates: make(map[string]DomainCertificate),
+
+		cdnCertNotAfter: prometheus.NewDesc(
+			prometheus.BuildFQName(cdnNameSpace, "cdn", "cert_not_after_seconds"),
+			"cdn域名证书过期时间(unix时间戳, 秒)",
+			[]string{
+				"domain",
+				"cert_id",
+				"common_name",
+			},
+			nil,
+		),
+		cdnHttpsEnabled: prometheus.NewDesc(
+			prometheus.BuildFQName(cdnNameSpace, "cdn", "https_enabled"),
+			"cdn域名是否开启https",
+			[]string{
+				"domain",
+			},
+			nil,
+		),
+	}
+}
+
+// SetCertificates 替换缓存的域名证书信息, err 为刷新中部分域名查询失败的错误
+func (e *CertificateExporter) SetCertificates(certificates map[string]DomainCertificate, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.certificates = certificates
+	e.err = err
+}
+
+// Certificate 返回缓存的域名证书信息
+func (e *CertificateExporter) Certificate(domain string) (DomainCertificate, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	certificate, ok := e.certificates[domain]
+	return certificate, ok
+}
+
+func (e *CertificateExporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- e.cdnCertNotAfter
+	ch <- e.cdnHttpsEnabled
+}
+
+// Update 输出缓存的证书信息, 返回最近一次刷新中的错误
+func (e *CertificateExporter) Update(ch chan<- prometheus.Metric) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for domain, certificate := range e.certificates {
+		httpsEnabled := 0.0
+		if certificate.HttpsEnabled {
+			httpsEnabled = 1
+		}
+		ch <- prometheus.MustNewConstMetric(
+			e.cdnHttpsEnabled,
+			prometheus.GaugeValue,
+			httpsEnabled,
+			domain,
+		)
+		if certificate.CertificateId == "" {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			e.cdnCertNotAfter,
+			prometheus.GaugeValue,
+			certificate.NotAfter,
+			domain,
+			certificate.CertificateId,
+			certificate.CommonName,
+		)
+	}
+	return e.err
+}
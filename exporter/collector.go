@@ -0,0 +1,233 @@
+package exporter
+
+import (
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"sort"
+	"sync"
+	"time"
+	"upyun-exporter/httpRequest"
+	"upyun-exporter/logging"
+)
+
+// cdn collector 名称, 由 CdnExporter 按域名采集
+const (
+	CollectorBandwidth  = "bandwidth"
+	CollectorCdnFlow    = "cdn_flow"
+	CollectorBackSource = "backsource"
+)
+
+// 不按域名查询窗口采集的 collector 名称, 由 CollectorSet 采集
+const (
+	CollectorStorage     = "storage"
+	CollectorCertificate = "certificate"
+	CollectorPurge       = "purge"
+	CollectorTop         = "top"
+	CollectorAccessLog   = "accesslog"
+)
+
+// collectorFunc 采集单个域名在 window 内的一类 cdn 数据, 结果写入 snapshot 中该 collector 对应的字段
+type collectorFunc func(e *CdnExporter, domain string, window httpRequest.Window, snapshot *DomainSnapshot) error
+
+type collectorEntry struct {
+	defaultEnabled bool
+	// 为 nil 时不是 cdn collector, 由 CollectorSet 采集
+	update collectorFunc
+}
+
+var collectors = make(map[string]collectorEntry)
+
+func registerCollector(name string, defaultEnabled bool, update collectorFunc) {
+	collectors[name] = collectorEntry{defaultEnabled: defaultEnabled, update: update}
+}
+
+func init() {
+	registerCollector(CollectorBandwidth, true, updateBandWidth)
+	registerCollector(CollectorCdnFlow, true, updateCdnFlow)
+	registerCollector(CollectorBackSource, true, updateBackSource)
+	registerCollector(CollectorStorage, true, nil)
+	registerCollector(CollectorCertificate, true, nil)
+	registerCollector(CollectorPurge, false, nil)
+	registerCollector(CollectorTop, false, nil)
+	registerCollector(CollectorAccessLog, false, nil)
+}
+
+// CollectorNames 返回所有 collector 名称, 按名称排序
+func CollectorNames() []string {
+	names := make([]string, 0, len(collectors))
+	for name := range collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CollectorDefaultEnabled 返回 collector 是否默认启用
+func CollectorDefaultEnabled(name string) bool {
+	return collectors[name].defaultEnabled
+}
+
+// cdnCollectorNames 返回所有 cdn collector 名称, 按名称排序
+func cdnCollectorNames() []string {
+	var names []string
+	for _, name := range CollectorNames() {
+		if isCdnCollector(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func isCdnCollector(name string) bool {
+	entry, ok := collectors[name]
+	return ok && entry.update != nil
+}
+
+func updateBandWidth(e *CdnExporter, domain string, window httpRequest.Window, snapshot *DomainSnapshot) error {
+	// interval - min_five
+	cdnRequestData, err := httpRequest.DoHttpBandWidthRequest(domain, e.token, window)
+	if err != nil {
+		level.Warn(logging.Logger).Log("msg", "Failed to get bandwidth data", "domain", domain, "err", err)
+		return err
+	}
+	if cdnRequestData.Interval != "" {
+		e.setInterval(domain, cdnRequestData.IntervalDuration())
+	}
+	// 请求数或带宽为 0 时不输出
+	stats, ok := CalculateBandWidthStats(cdnRequestData)
+	if ok {
+		snapshot.BandWidth = &stats
+	}
+	return nil
+}
+
+func updateCdnFlow(e *CdnExporter, domain string, window httpRequest.Window, snapshot *DomainSnapshot) error {
+	cdnFlowDetailData, err := httpRequest.DoHttpFlowDetailRequest(domain, e.token, window, "cdn")
+	if err != nil {
+		level.Warn(logging.Logger).Log("msg", "Failed to get cdn flow detail", "domain", domain, "err", err)
+		return err
+	}
+	// 窗口内没有数据时不输出
+	if len(cdnFlowDetailData) == 0 {
+		return nil
+	}
+	stats := CalculateFlowStats(cdnFlowDetailData)
+	snapshot.Flow = &stats
+	return nil
+}
+
+func updateBackSource(e *CdnExporter, domain string, window httpRequest.Window, snapshot *DomainSnapshot) error {
+	resourceRequestData, err := httpRequest.DoHttpFlowDetailRequest(domain, e.token, window, "backsource")
+	if err != nil {
+		level.Warn(logging.Logger).Log("msg", "Failed to get backsource flow detail", "domain", domain, "err", err)
+		return err
+	}
+	// 没有回源数据时不输出
+	if len(resourceRequestData) == 0 {
+		return nil
+	}
+	stats := CalculateBackSourceStats(resourceRequestData, e.interval(domain))
+	snapshot.BackSource = &stats
+	return nil
+}
+
+// Collector 不按域名查询窗口采集的一类数据, Update 输出指标并返回采集中的错误
+type Collector interface {
+	Describe(ch chan<- *prometheus.Desc)
+	Update(ch chan<- prometheus.Metric) error
+}
+
+// 所有 collector 本次抓取的耗时及是否成功, cdn collector 的耗时为采集所有域名的耗时,
+// 只要有一个域名采集失败即为失败. 同一个 Desc 只能由一个注册的 collector 描述, 因此 CdnExporter 通过 CollectorSet.AddGroup 一并注册
+var (
+	scrapeCollectorDuration = prometheus.NewDesc(
+		prometheus.BuildFQName(cdnNameSpace, "scrape", "collector_duration_seconds"),
+		"本次抓取中collector的耗时(秒)",
+		[]string{
+			"collector",
+		},
+		nil,
+	)
+	scrapeCollectorSuccess = prometheus.NewDesc(
+		prometheus.BuildFQName(cdnNameSpace, "scrape", "collector_success"),
+		"本次抓取中collector是否采集成功",
+		[]string{
+			"collector",
+		},
+		nil,
+	)
+)
+
+// CollectorSet 并发采集添加的 Collector, 并导出各 collector 本次抓取的耗时及是否成功
+type CollectorSet struct {
+	mu         sync.Mutex
+	names      []string
+	collectors map[string]Collector
+	// 自行导出各 collector 耗时及是否成功的一组 collector, 如 CdnExporter
+	groups []prometheus.Collector
+}
+
+func NewCollectorSet() *CollectorSet {
+	return &CollectorSet{
+		collectors: make(map[string]Collector),
+	}
+}
+
+// Add 添加名为 name 的 collector, 需在注册到 Prometheus 前添加
+func (s *CollectorSet) Add(name string, collector Collector) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.names = append(s.names, name)
+	s.collectors[name] = collector
+}
+
+// AddGroup 添加自行导出各 collector 耗时及是否成功的一组 collector, 需在注册到 Prometheus 前添加
+func (s *CollectorSet) AddGroup(group prometheus.Collector) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.groups = append(s.groups, group)
+}
+
+func (s *CollectorSet) Describe(ch chan<- *prometheus.Desc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range s.names {
+		s.collectors[name].Describe(ch)
+	}
+	for _, group := range s.groups {
+		group.Describe(ch)
+	}
+	ch <- scrapeCollectorDuration
+	ch <- scrapeCollectorSuccess
+}
+
+func (s *CollectorSet) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	names := append([]string(nil), s.names...)
+	groups := append([]prometheus.Collector(nil), s.groups...)
+	s.mu.Unlock()
+	stats := newCollectorStats(names)
+	var wg sync.WaitGroup
+	for _, group := range groups {
+		group := group
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			group.Collect(ch)
+		}()
+	}
+	for _, name := range names {
+		name := name
+		collector := s.collectors[name]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			// 各 collector 自行记录错误日志
+			err := collector.Update(ch)
+			stats.observe(name, time.Since(start), err)
+		}()
+	}
+	wg.Wait()
+	stats.emit(ch)
+}
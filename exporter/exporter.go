@@ -2,25 +2,27 @@ package exporter
 
 import (
 	"errors"
-	"fmt"
+	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
-	"log"
-	"strconv"
 	"sync"
+	"time"
 	"upyun-exporter/httpRequest"
+	"upyun-exporter/logging"
 )
 
 const cdnNameSpace = "upyun"
 
-func calculateRequestCountPerMin(code float64) float64 {
-	return code / 5
+// calculateRequestCountPerMin 将每个聚合粒度内的请求数换算为每分钟请求数
+func calculateRequestCountPerMin(count float64, interval time.Duration) float64 {
+	return count / interval.Minutes()
 }
 
 type CdnExporter struct {
-	domainList              *[]string
-	token                   string
-	rangeTime               int64
-	delayTime               int64
+	domainList func() []string
+	token      *httpRequest.Token
+	settings   *Settings
+	// 规则中配置的附加 label
+	labelNames              []string
 	cdnRequestCount         *prometheus.Desc
 	cdnResourceRequestCount *prometheus.Desc
 	cdnHitRate              *prometheus.Desc
@@ -29,79 +31,152 @@ type CdnExporter struct {
 	cdnResourceBandWidth    *prometheus.Desc
 	cdnStatusRate           *prometheus.Desc
 	cdnBackSourceStatusRate *prometheus.Desc
+	cdnWindowStart          *prometheus.Desc
+	cdnWindowEnd            *prometheus.Desc
+	cdnWindowLength         *prometheus.Desc
+	cdnWindowLag            *prometheus.Desc
+	// 启用的 collector
+	collectors []string
+	mu         sync.Mutex
+	// 各域名最近一次带宽接口返回的聚合粒度, 用于对齐查询窗口
+	intervals map[string]time.Duration
+	// 最近一次 Collect 中采集失败的域名及错误
+	failedDomains map[string]error
+	// 设置了 refresh_interval 的域名最近一次成功的采集结果
+	cache map[string]DomainSnapshot
+	// 每次采集后接收采集结果的输出
+	sinks []*sinkWriter
+	// 各 sink 的写入 goroutine, Close 时等待其写完队列中的结果
+	sinkWriters sync.WaitGroup
+	// Close 之后不再写入 sink
+	closed bool
+	// 为 true 时只在 Refresh 时查询 UpYun 并写入 sink, Collect 输出最近一次 Refresh 的指标
+	refreshOnly bool
+	refreshed   []prometheus.Metric
 }
 
-func CdnCloudExporter(domainList *[]string, token string, rangeTime int64, delayTime int64) *CdnExporter {
+// DomainSnapshot 一次 Collect 中单个域名的采集结果, 未采集到的部分为 nil
+type DomainSnapshot struct {
+	Domain string    `json:"domain"`
+	Time   time.Time `json:"time"`
+	// 实际查询的窗口
+	WindowStart time.Time        `json:"window_start"`
+	WindowEnd   time.Time        `json:"window_end"`
+	BandWidth   *BandWidthStats  `json:"bandwidth"`
+	Flow        *FlowStats       `json:"flow"`
+	BackSource  *BackSourceStats `json:"backsource"`
+	// 采集失败时的错误信息
+	Error string `json:"error,omitempty"`
+}
+
+// WindowConfig 查询窗口配置, Lengths 及 Lags 中的域名单独覆盖窗口长度或延迟, 未覆盖的项使用 Default
+type WindowConfig struct {
+	Default httpRequest.WindowSpec
+	Lengths map[string]time.Duration
+	Lags    map[string]time.Duration
+}
+
+// For 返回域名实际使用的窗口配置
+func (c WindowConfig) For(domain string) httpRequest.WindowSpec {
+	return c.Override(domain, c.Default)
+}
+
+// Override 在 spec 上逐项应用域名单独设置的长度及延迟
+func (c WindowConfig) Override(domain string, spec httpRequest.WindowSpec) httpRequest.WindowSpec {
+	if length, ok := c.Lengths[domain]; ok {
+		spec.Length = length
+	}
+	if lag, ok := c.Lags[domain]; ok {
+		spec.Lag = lag
+	}
+	return spec
+}
+
+func CdnCloudExporter(domainList func() []string, token *httpRequest.Token, settings *Settings) *CdnExporter {
+	labelNames := settings.labelNames()
+	domainLabels := append([]string{"instanceId"}, labelNames...)
+	statusLabels := append([]string{"instanceId", "status"}, labelNames...)
 	return &CdnExporter{
 		domainList: domainList,
 		token:      token,
-		rangeTime:  rangeTime,
-		delayTime:  delayTime,
+		settings:   settings,
+		labelNames: labelNames,
+		collectors: settings.enabledCollectors(),
+
+		failedDomains: make(map[string]error),
+		intervals:     make(map[string]time.Duration),
+		cache:         make(map[string]DomainSnapshot),
 
 		cdnRequestCount: prometheus.NewDesc(
 			prometheus.BuildFQName(cdnNameSpace, "cdn", "request_count"),
 			"cdn总请求数(次/分钟)",
-			[]string{
-				"instanceId",
-			},
+			domainLabels,
 			nil,
 		),
 		cdnResourceRequestCount: prometheus.NewDesc(
 			prometheus.BuildFQName(cdnNameSpace, "cdn", "resource_request_count"),
 			"cdn回源总请求数(次/分钟)",
-			[]string{
-				"instanceId",
-			},
+			domainLabels,
 			nil,
 		),
 		cdnHitRate: prometheus.NewDesc(
 			prometheus.BuildFQName(cdnNameSpace, "cdn", "hit_rate"),
 			"cdn缓存命中率(%)",
-			[]string{
-				"instanceId",
-			},
+			domainLabels,
 			nil,
 		),
 		cdnFluxHitRate: prometheus.NewDesc(
 			prometheus.BuildFQName(cdnNameSpace, "cdn", "flux_hit_rate"),
 			"cdn缓存字节命中率(%)",
-			[]string{
-				"instanceId",
-			},
+			domainLabels,
 			nil,
 		),
 		cdnBandWidth: prometheus.NewDesc(
 			prometheus.BuildFQName(cdnNameSpace, "cdn", "bandwidth"),
 			"cdn总带宽(Mbps)",
-			[]string{
-				"instanceId",
-			},
+			domainLabels,
 			nil,
 		),
 		cdnResourceBandWidth: prometheus.NewDesc(
 			prometheus.BuildFQName(cdnNameSpace, "backsource", "resource_bandwidth"),
 			"回源带宽(Mbps)",
-			[]string{
-				"instanceId",
-			},
+			domainLabels,
 			nil,
 		),
 		cdnStatusRate: prometheus.NewDesc(
 			prometheus.BuildFQName(cdnNameSpace, "cdn", "status_rate"),
 			"cdn状态码概率(%)",
-			[]string{
-				"instanceId",
-				"status",
-			},
+			statusLabels,
 			nil,
 		),
 		cdnBackSourceStatusRate: prometheus.NewDesc(
 			prometheus.BuildFQName(cdnNameSpace, "cdn", "backsource_status_rate"),
 			"cdn回源状态码概率(%)",
-			[]string{
-				"instanceId",
-				"status",
-			},
+			statusLabels,
+			nil,
+		),
+		cdnWindowStart: prometheus.NewDesc(
+			prometheus.BuildFQName(cdnNameSpace, "cdn", "window_start_timestamp_seconds"),
+			"cdn指标查询窗口的开始时间, 已对齐到UpYun聚合粒度",
+			domainLabels,
+			nil,
+		),
+		cdnWindowEnd: prometheus.NewDesc(
+			prometheus.BuildFQName(cdnNameSpace, "cdn", "window_end_timestamp_seconds"),
+			"cdn指标查询窗口的结束时间, 已对齐到UpYun聚合粒度",
+			domainLabels,
+			nil,
+		),
+		cdnWindowLength: prometheus.NewDesc(
+			prometheus.BuildFQName(cdnNameSpace, "cdn", "window_length_seconds"),
+			"cdn指标查询窗口的配置长度(秒)",
+			domainLabels,
+			nil,
+		),
+		cdnWindowLag: prometheus.NewDesc(
+			prometheus.BuildFQName(cdnNameSpace, "cdn", "window_lag_seconds"),
+			"cdn指标查询窗口结束时间距当前时间的配置延迟(秒)",
+			domainLabels,
 			nil,
 		),
 	}
@@ -116,252 +191,302 @@ func (e *CdnExporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- e.cdnResourceBandWidth
 	ch <- e.cdnStatusRate
 	ch <- e.cdnBackSourceStatusRate
+	ch <- e.cdnWindowStart
+	ch <- e.cdnWindowEnd
+	ch <- e.cdnWindowLength
+	ch <- e.cdnWindowLag
+	ch <- scrapeCollectorDuration
+	ch <- scrapeCollectorSuccess
+}
+
+// window 返回域名本次采集的查询窗口, 按上次返回的聚合粒度对齐
+func (e *CdnExporter) window(domain string, spec httpRequest.WindowSpec, now time.Time) httpRequest.Window {
+	return spec.At(now).Align(e.interval(domain))
+}
+
+// interval 返回域名上次带宽接口返回的聚合粒度, 还没有返回过时为 DefaultInterval
+func (e *CdnExporter) interval(domain string) time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if interval, ok := e.intervals[domain]; ok {
+		return interval
+	}
+	return httpRequest.DefaultInterval
+}
+
+// cached 返回域名在 refreshInterval 内的上次采集结果
+func (e *CdnExporter) cached(domain string, refreshInterval time.Duration, now time.Time) (DomainSnapshot, bool) {
+	if refreshInterval <= 0 {
+		return DomainSnapshot{}, false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	snapshot, ok := e.cache[domain]
+	if !ok || now.Sub(snapshot.Time) >= refreshInterval {
+		return DomainSnapshot{}, false
+	}
+	return snapshot, true
+}
+
+func (e *CdnExporter) setInterval(domain string, interval time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.intervals[domain] = interval
+}
+
+// AddSink 添加一个输出, 每次采集完成后将所有域名的采集结果异步写入
+func (e *CdnExporter) AddSink(sink Sink) {
+	writer := &sinkWriter{sink: sink, queue: make(chan []DomainSnapshot, 1)}
+	e.sinkWriters.Add(1)
+	go func() {
+		defer e.sinkWriters.Done()
+		writer.run()
+	}()
+	e.sinks = append(e.sinks, writer)
+}
+
+// Close 停止写入 sink, 并等待各 sink 写完已排队的结果, 退出前调用
+func (e *CdnExporter) Close() {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		for _, writer := range e.sinks {
+			close(writer.queue)
+		}
+	}
+	e.mu.Unlock()
+	e.sinkWriters.Wait()
+}
+
+// SetRefreshOnly 设置为 true 时只由 Refresh 查询 UpYun 并写入 sink, Prometheus 抓取输出最近一次 Refresh 的指标,
+// 避免定期写入 sink 时抓取重复查询. 需在开始抓取前设置
+func (e *CdnExporter) SetRefreshOnly(refreshOnly bool) {
+	e.refreshOnly = refreshOnly
+}
+
+// Refresh 执行一次采集并写入 sink, 用于定期驱动 sink
+func (e *CdnExporter) Refresh() {
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	var metrics []prometheus.Metric
+	go func() {
+		for metric := range ch {
+			metrics = append(metrics, metric)
+		}
+		close(done)
+	}()
+	snapshots := e.collect(ch)
+	close(ch)
+	<-done
+	e.mu.Lock()
+	e.refreshed = metrics
+	e.mu.Unlock()
+	e.writeSinks(snapshots)
+}
+
+// writeSinks 将采集结果交给各 sink 的写入 goroutine, sink 仍在写入上一次结果时丢弃本次结果
+func (e *CdnExporter) writeSinks(snapshots []DomainSnapshot) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	for _, writer := range e.sinks {
+		select {
+		case writer.queue <- snapshots:
+		default:
+			level.Warn(logging.Logger).Log("msg", "Sink is still writing previous cdn metrics, dropping", "sink", writer.sink.Name())
+		}
+	}
+}
+
+// sinkWriter 在单独的 goroutine 中依次写入 sink, 避免慢速 sink 拖慢抓取
+type sinkWriter struct {
+	sink  Sink
+	queue chan []DomainSnapshot
+}
+
+func (w *sinkWriter) run() {
+	for snapshots := range w.queue {
+		if err := w.sink.Write(snapshots); err != nil {
+			level.Warn(logging.Logger).Log("msg", "Failed to write cdn metrics to sink", "sink", w.sink.Name(), "err", err)
+		}
+	}
+}
+
+// recordSnapshotError 记录域名的采集错误, 同一域名多个 collector 失败时错误信息依次拼接
+func (e *CdnExporter) recordSnapshotError(snapshot *DomainSnapshot, collector string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if snapshot.Error != "" {
+		snapshot.Error += "; "
+	}
+	snapshot.Error += collector + ": " + err.Error()
+	// 保留所有失败 collector 的错误, --once 及 Pushgateway 模式据此报告失败
+	e.failedDomains[snapshot.Domain] = errors.New(snapshot.Error)
+}
+
+// collectorStats 一次抓取中各 collector 的耗时及是否全部成功
+type collectorStats struct {
+	mu       sync.Mutex
+	duration map[string]time.Duration
+	success  map[string]bool
+}
+
+func newCollectorStats(names []string) *collectorStats {
+	stats := &collectorStats{
+		duration: make(map[string]time.Duration),
+		success:  make(map[string]bool),
+	}
+	for _, name := range names {
+		stats.success[name] = true
+	}
+	return stats
+}
+
+// observe 记录 collector 完成一个域名, 耗时取所有域名中最晚完成的时间
+func (s *collectorStats) observe(name string, elapsed time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elapsed > s.duration[name] {
+		s.duration[name] = elapsed
+	}
+	if err != nil {
+		s.success[name] = false
+	}
+}
+
+// emit 输出各 collector 的 upyun_scrape_collector_duration_seconds 及 upyun_scrape_collector_success
+func (s *collectorStats) emit(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, success := range s.success {
+		value := 0.0
+		if success {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(scrapeCollectorDuration, prometheus.GaugeValue, s.duration[name].Seconds(), name)
+		ch <- prometheus.MustNewConstMetric(scrapeCollectorSuccess, prometheus.GaugeValue, value, name)
+	}
+}
+
+// FailedDomains 返回最近一次 Collect 中采集失败的域名及错误
+func (e *CdnExporter) FailedDomains() map[string]error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	failedDomains := make(map[string]error, len(e.failedDomains))
+	for domain, err := range e.failedDomains {
+		failedDomains[domain] = err
+	}
+	return failedDomains
 }
 
 func (e *CdnExporter) Collect(ch chan<- prometheus.Metric) {
-	if len(*e.domainList) == 0 {
+	if e.refreshOnly {
+		e.mu.Lock()
+		metrics := e.refreshed
+		e.mu.Unlock()
+		for _, metric := range metrics {
+			ch <- metric
+		}
+		return
+	}
+	e.writeSinks(e.collect(ch))
+}
+
+// collect 查询所有域名并输出指标, 返回各域名的采集结果
+func (e *CdnExporter) collect(ch chan<- prometheus.Metric) []DomainSnapshot {
+	e.mu.Lock()
+	e.failedDomains = make(map[string]error)
+	e.mu.Unlock()
+	domains := e.domainList()
+	if len(domains) == 0 {
 		ch <- prometheus.NewInvalidMetric(
 			prometheus.NewDesc("upyun_exporter",
 				"Error collecting cdn metrics", nil, nil),
 			errors.New("empty domain list"))
 	}
 	var wg sync.WaitGroup
-	for _, domain := range *e.domainList {
+	now := time.Now()
+	stats := newCollectorStats(e.collectors)
+	snapshots := make([]DomainSnapshot, len(domains))
+	settings := make([]DomainSettings, len(domains))
+	fresh := make([]bool, len(domains))
+	for i, domain := range domains {
 		domain := domain
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			var (
-				cdnBandWidthTotal float64
-			)
-			// 内部有个 判断数据量为 0的逻辑, 所以没法加入 wait group
-			// interval - min_five
-			cdnRequestData := httpRequest.DoHttpBandWidthRequest(domain, e.token, e.rangeTime, e.delayTime)
-			var requestCountTotal float64
-			for _, point := range cdnRequestData.Data {
-				requestCountTotal += point.Reqs
-				cdnBandWidthTotal += point.Bandwidth
-			}
-			// 去掉数据量为0的数据，得到的结果是NaN
-			if requestCountTotal == 0 || cdnBandWidthTotal == 0 {
-				return
-			}
-			requestCountAverage := requestCountTotal / float64(len(cdnRequestData.Data))
-			cdnBandWidthAverage := cdnBandWidthTotal / float64(len(cdnRequestData.Data))
-			ch <- prometheus.MustNewConstMetric(
-				e.cdnRequestCount,
-				prometheus.GaugeValue,
-				calculateRequestCountPerMin(requestCountAverage),
-				domain,
-			)
-			ch <- prometheus.MustNewConstMetric(
-				e.cdnBandWidth,
-				prometheus.GaugeValue,
-				cdnBandWidthAverage/1000/1000,
-				domain,
-			)
-		}()
-
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			cdnFlowDetailData, err := httpRequest.DoHttpFlowDetailRequest(domain, e.token, e.rangeTime, e.delayTime, "cdn")
-			if err != nil {
-				if err.T == httpRequest.ResponseCodeNot200 {
-					ch <- prometheus.NewInvalidMetric(
-						prometheus.NewDesc("upyun_exporter",
-							"Error collecting cdn flow details", nil, nil),
-						err)
-					log.Fatalf("error getting metric, return code not 200, error: %s", err)
-				} else {
-					log.Printf("failed to get cdn flow detail, error: %s", err)
-					return
-				}
-			}
+		settings[i] = e.settings.For(domain)
+		if cached, ok := e.cached(domain, settings[i].RefreshInterval, now); ok {
+			snapshots[i] = cached
+			continue
+		}
+		fresh[i] = true
+		snapshot := &snapshots[i]
+		snapshot.Domain = domain
+		snapshot.Time = now
+		window := e.window(domain, settings[i].Window, now)
+		snapshot.WindowStart, snapshot.WindowEnd = window.Start, window.End
 
-			statusCodes := make(map[string]float64)
-			var (
-				cdnHitRateTotal     float64
-				cdnFlowHitRateTotal float64
-				codeTotal           int
-				code200Total        int
-				code206Total        int
-				code301Total        int
-				code302Total        int
-				code304Total        int
-				code400Total        int
-				code403Total        int
-				code404Total        int
-				code411Total        int
-				code499Total        int
-				code500Total        int
-				code502Total        int
-				code503Total        int
-				code504Total        int
-			)
-
-			for _, point := range cdnFlowDetailData {
-				// FIXME: upyun treats 403 as not hit
-				cdnHitRateTotal = cdnHitRateTotal + (float64(point.Hit) + float64(point.Code403)) / float64(point.Reqs)
-				cdnFlowHitRateTotal = cdnFlowHitRateTotal + (float64(point.HitBytes) / float64(point.Bytes))
-				code200Total += point.Code200
-				code206Total += point.Code206
-				code301Total += point.Code301
-				code302Total += point.Code302
-				code304Total += point.Code304
-				code400Total += point.Code400
-				code403Total += point.Code403
-				code404Total += point.Code404
-				code411Total += point.Code411
-				code499Total += point.Code499
-				code500Total += point.Code500
-				code502Total += point.Code502
-				code503Total += point.Code503
-				code504Total += point.Code504
-			}
-			codeTotal = code200Total + code206Total + code301Total + code302Total + code304Total + code400Total + code403Total +
-				code404Total + code411Total + code499Total + code500Total + code502Total + code503Total + code504Total
-			statusCodes["200"] = float64(code200Total) / float64(codeTotal)
-			statusCodes["206"] = float64(code206Total) / float64(codeTotal)
-			statusCodes["2xx"] = float64(code200Total+code206Total) / float64(codeTotal)
-			statusCodes["301"] = float64(code301Total) / float64(codeTotal)
-			statusCodes["302"] = float64(code302Total) / float64(codeTotal)
-			statusCodes["304"] = float64(code304Total) / float64(codeTotal)
-			statusCodes["3xx"] = float64(code301Total+code302Total+code304Total) / float64(codeTotal)
-			statusCodes["400"] = float64(code400Total) / float64(codeTotal)
-			statusCodes["403"] = float64(code403Total) / float64(codeTotal)
-			statusCodes["404"] = float64(code404Total) / float64(codeTotal)
-			statusCodes["411"] = float64(code411Total) / float64(codeTotal)
-			statusCodes["499"] = float64(code499Total) / float64(codeTotal)
-			statusCodes["4xx"] = float64(code400Total+code403Total+code404Total+code411Total+code499Total) / float64(codeTotal)
-			statusCodes["500"] = float64(code500Total) / float64(codeTotal)
-			statusCodes["502"] = float64(code502Total) / float64(codeTotal)
-			statusCodes["503"] = float64(code503Total) / float64(codeTotal)
-			statusCodes["504"] = float64(code504Total) / float64(codeTotal)
-			statusCodes["5xx"] = float64(code500Total+code502Total+code503Total+code504Total) / float64(codeTotal)
-
-			cdnHitRateAverage, _ := strconv.ParseFloat(fmt.Sprintf("%.3f", (cdnHitRateTotal/float64(len(cdnFlowDetailData)))*100), 64)
-			cdnFlowHitRateAverage, _ := strconv.ParseFloat(fmt.Sprintf("%.3f", (cdnFlowHitRateTotal/float64(len(cdnFlowDetailData)))*100), 64)
-			ch <- prometheus.MustNewConstMetric(
-				e.cdnHitRate,
-				prometheus.GaugeValue,
-				cdnHitRateAverage,
-				domain,
-			)
-			ch <- prometheus.MustNewConstMetric(
-				e.cdnFluxHitRate,
-				prometheus.GaugeValue,
-				cdnFlowHitRateAverage,
-				domain,
-			)
-			for status, rate := range statusCodes {
-				statusRate, _ := strconv.ParseFloat(fmt.Sprintf("%.3f", rate*100), 64)
-				ch <- prometheus.MustNewConstMetric(
-					e.cdnStatusRate,
-					prometheus.GaugeValue,
-					statusRate,
-					domain,
-					status,
-				)
-			}
-		}()
-
-		// 回源数据
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			var (
-				resourceBandwidthTotal float64
-				resourceReqsTotal      int
-				resourceCodeTotal      int
-				resourceCode200Total   int
-				resourceCode206Total   int
-				resourceCode301Total   int
-				resourceCode302Total   int
-				resourceCode304Total   int
-				resourceCode400Total   int
-				resourceCode403Total   int
-				resourceCode404Total   int
-				resourceCode411Total   int
-				resourceCode499Total   int
-				resourceCode500Total   int
-				resourceCode502Total   int
-				resourceCode503Total   int
-				resourceCode504Total   int
-			)
-
-			resourceRequestData, err := httpRequest.DoHttpFlowDetailRequest(domain, e.token, e.rangeTime, e.delayTime, "backsource")
-			// response 返回为 {}
-			if err != nil {
-				return
-			}
-			for _, point := range resourceRequestData {
-				resourceCode200Total += point.Code200
-				resourceCode206Total += point.Code206
-				resourceCode301Total += point.Code301
-				resourceCode302Total += point.Code302
-				resourceCode304Total += point.Code304
-				resourceCode400Total += point.Code400
-				resourceCode403Total += point.Code403
-				resourceCode404Total += point.Code404
-				resourceCode411Total += point.Code411
-				resourceCode499Total += point.Code499
-				resourceCode500Total += point.Code500
-				resourceCode502Total += point.Code502
-				resourceCode503Total += point.Code503
-				resourceCode504Total += point.Code504
-				resourceBandwidthTotal += point.Bandwidth
-				resourceReqsTotal += point.Reqs
+		// 各 collector 分别只写入 snapshot 的不同字段, Error 在锁内写入
+		for _, name := range e.collectors {
+			if !settings[i].Enabled(name) {
+				continue
 			}
-			resourceStatusCodes := make(map[string]float64)
-			resourceCodeTotal = resourceCode200Total + resourceCode206Total + resourceCode301Total + resourceCode302Total +
-				resourceCode304Total + resourceCode400Total + resourceCode403Total + resourceCode404Total + resourceCode411Total +
-				resourceCode499Total + resourceCode500Total + resourceCode502Total + resourceCode503Total + resourceCode504Total
-
-			resourceStatusCodes["200"] = float64(resourceCode200Total) / float64(resourceCodeTotal)
-			resourceStatusCodes["206"] = float64(resourceCode206Total) / float64(resourceCodeTotal)
-			resourceStatusCodes["2xx"] = float64(resourceCode200Total+resourceCode206Total) / float64(resourceCodeTotal)
-			resourceStatusCodes["301"] = float64(resourceCode301Total) / float64(resourceCodeTotal)
-			resourceStatusCodes["302"] = float64(resourceCode302Total) / float64(resourceCodeTotal)
-			resourceStatusCodes["304"] = float64(resourceCode304Total) / float64(resourceCodeTotal)
-			resourceStatusCodes["3xx"] = float64(resourceCode301Total+resourceCode302Total+resourceCode304Total) / float64(resourceCodeTotal)
-			resourceStatusCodes["400"] = float64(resourceCode400Total) / float64(resourceCodeTotal)
-			resourceStatusCodes["403"] = float64(resourceCode403Total) / float64(resourceCodeTotal)
-			resourceStatusCodes["404"] = float64(resourceCode404Total) / float64(resourceCodeTotal)
-			resourceStatusCodes["411"] = float64(resourceCode411Total) / float64(resourceCodeTotal)
-			resourceStatusCodes["499"] = float64(resourceCode499Total) / float64(resourceCodeTotal)
-			resourceStatusCodes["4xx"] = float64(resourceCode400Total+resourceCode403Total+resourceCode404Total+resourceCode411Total+resourceCode499Total) / float64(resourceCodeTotal)
-			resourceStatusCodes["500"] = float64(resourceCode500Total) / float64(resourceCodeTotal)
-			resourceStatusCodes["502"] = float64(resourceCode502Total) / float64(resourceCodeTotal)
-			resourceStatusCodes["503"] = float64(resourceCode503Total) / float64(resourceCodeTotal)
-			resourceStatusCodes["504"] = float64(resourceCode504Total) / float64(resourceCodeTotal)
-			resourceStatusCodes["5xx"] = float64(resourceCode500Total+resourceCode502Total+resourceCode503Total+resourceCode504Total) / float64(resourceCodeTotal)
-			resourceBandwidthAverage := resourceBandwidthTotal / float64(len(resourceRequestData))
-			resourceReqsAverage := float64(resourceReqsTotal) / float64(len(resourceRequestData))
-			ch <- prometheus.MustNewConstMetric(
-				e.cdnResourceBandWidth,
-				prometheus.GaugeValue,
-				resourceBandwidthAverage/1000/1000,
-				domain,
-			)
-
-			ch <- prometheus.MustNewConstMetric(
-				e.cdnResourceRequestCount,
-				prometheus.GaugeValue,
-				calculateRequestCountPerMin(resourceReqsAverage),
-				domain,
-			)
-			for status, rate := range resourceStatusCodes {
-				statusRate, _ := strconv.ParseFloat(fmt.Sprintf("%.3f", rate*100), 64)
-				ch <- prometheus.MustNewConstMetric(
-					e.cdnBackSourceStatusRate,
-					prometheus.GaugeValue,
-					statusRate,
-					domain,
-					status,
-				)
-			}
-		}()
+			name := name
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := collectors[name].update(e, domain, window, snapshot)
+				stats.observe(name, time.Since(now), err)
+				if err != nil {
+					e.recordSnapshotError(snapshot, name, err)
+				}
+			}()
+		}
 	}
 	wg.Wait()
+
+	for i := range snapshots {
+		e.emit(ch, snapshots[i], settings[i])
+		if fresh[i] && settings[i].RefreshInterval > 0 && snapshots[i].Error == "" {
+			e.mu.Lock()
+			e.cache[snapshots[i].Domain] = snapshots[i]
+			e.mu.Unlock()
+		}
+	}
+	stats.emit(ch)
+	return snapshots
+}
+
+// emit 输出单个域名的采集结果
+func (e *CdnExporter) emit(ch chan<- prometheus.Metric, snapshot DomainSnapshot, settings DomainSettings) {
+	gauge := func(desc *prometheus.Desc, value float64, labelValues ...string) {
+		labelValues = append([]string{snapshot.Domain}, labelValues...)
+		for _, name := range e.labelNames {
+			labelValues = append(labelValues, settings.Labels[name])
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labelValues...)
+	}
+
+	gauge(e.cdnWindowLength, settings.Window.Length.Seconds())
+	gauge(e.cdnWindowLag, settings.Window.Lag.Seconds())
+	gauge(e.cdnWindowStart, float64(snapshot.WindowStart.Unix()))
+	gauge(e.cdnWindowEnd, float64(snapshot.WindowEnd.Unix()))
+	if snapshot.BandWidth != nil {
+		gauge(e.cdnRequestCount, snapshot.BandWidth.RequestCount)
+		gauge(e.cdnBandWidth, snapshot.BandWidth.BandWidth)
+	}
+	if snapshot.Flow != nil {
+		gauge(e.cdnHitRate, snapshot.Flow.HitRate)
+		gauge(e.cdnFluxHitRate, snapshot.Flow.FluxHitRate)
+		for status, statusRate := range snapshot.Flow.StatusRate {
+			gauge(e.cdnStatusRate, statusRate, status)
+		}
+	}
+	if snapshot.BackSource != nil {
+		gauge(e.cdnResourceBandWidth, snapshot.BackSource.BandWidth)
+		gauge(e.cdnResourceRequestCount, snapshot.BackSource.RequestCount)
+		for status, statusRate := range snapshot.BackSource.StatusRate {
+			gauge(e.cdnBackSourceStatusRate, statusRate, status)
+		}
+	}
 }
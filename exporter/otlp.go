@@ -0,0 +1,178 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"strings"
+	"time"
+)
+
+// cdn 指标中表示域名的 label
+const domainLabel = "instanceId"
+
+type OtlpConfig struct {
+	// grpc 或 http
+	Protocol string
+	// host:port 或完整 URL
+	Endpoint string
+	Insecure bool
+	Headers  map[string]string
+	// 作为 resource 属性 upyun.account 的账号名
+	Account string
+}
+
+// OtlpSink 将 CdnExporter 的采集结果以与 Prometheus 相同的指标名及 label 作为 OTLP gauge 推送.
+// 每个域名单独作为一个 resource 推送, 带有 upyun.account, upyun.bucket, upyun.domain 属性
+type OtlpSink struct {
+	cdn          *CdnExporter
+	exporter     sdkmetric.Exporter
+	config       OtlpConfig
+	domainBucket func(domain string) string
+}
+
+func NewOtlpSink(cdn *CdnExporter, config OtlpConfig, domainBucket func(domain string) string) (*OtlpSink, error) {
+	var (
+		exporter sdkmetric.Exporter
+		err      error
+	)
+	ctx := context.Background()
+	switch config.Protocol {
+	case "grpc":
+		options := []otlpmetricgrpc.Option{otlpmetricgrpc.WithHeaders(config.Headers)}
+		if isURL(config.Endpoint) {
+			options = append(options, otlpmetricgrpc.WithEndpointURL(config.Endpoint))
+		} else {
+			options = append(options, otlpmetricgrpc.WithEndpoint(config.Endpoint))
+		}
+		if config.Insecure {
+			options = append(options, otlpmetricgrpc.WithInsecure())
+		}
+		exporter, err = otlpmetricgrpc.New(ctx, options...)
+	case "http":
+		options := []otlpmetrichttp.Option{otlpmetrichttp.WithHeaders(config.Headers)}
+		if isURL(config.Endpoint) {
+			options = append(options, otlpmetrichttp.WithEndpointURL(config.Endpoint))
+		} else {
+			options = append(options, otlpmetrichttp.WithEndpoint(config.Endpoint))
+		}
+		if config.Insecure {
+			options = append(options, otlpmetrichttp.WithInsecure())
+		}
+		exporter, err = otlpmetrichttp.New(ctx, options...)
+	default:
+		return nil, fmt.Errorf("unsupported otlp protocol %q, must be grpc or http", config.Protocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &OtlpSink{
+		cdn:          cdn,
+		exporter:     exporter,
+		config:       config,
+		domainBucket: domainBucket,
+	}, nil
+}
+
+func isURL(endpoint string) bool {
+	return strings.HasPrefix(endpoint, "http://") || strings.HasPrefix(endpoint, "https://")
+}
+
+func (s *OtlpSink) Name() string {
+	return "otlp"
+}
+
+// Shutdown 推送剩余数据并关闭 OTLP 连接
+func (s *OtlpSink) Shutdown(ctx context.Context) error {
+	return s.exporter.Shutdown(ctx)
+}
+
+// snapshotCollector 按 CdnExporter 的指标输出一次采集结果
+type snapshotCollector struct {
+	cdn       *CdnExporter
+	snapshots []DomainSnapshot
+}
+
+func (c snapshotCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.cdn.Describe(ch)
+}
+
+func (c snapshotCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, snapshot := range c.snapshots {
+		c.cdn.emit(ch, snapshot, c.cdn.settings.For(snapshot.Domain))
+	}
+}
+
+func (s *OtlpSink) Write(snapshots []DomainSnapshot) error {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(snapshotCollector{cdn: s.cdn, snapshots: snapshots}); err != nil {
+		return err
+	}
+	metricFamilies, err := registry.Gather()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	domainMetrics := make(map[string][]metricdata.Metrics)
+	for _, metricFamily := range metricFamilies {
+		dataPoints := make(map[string][]metricdata.DataPoint[float64])
+		for _, metric := range metricFamily.GetMetric() {
+			if metric.GetGauge() == nil {
+				continue
+			}
+			var (
+				domain     string
+				attributes []attribute.KeyValue
+			)
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == domainLabel {
+					domain = label.GetValue()
+					continue
+				}
+				attributes = append(attributes, attribute.String(label.GetName(), label.GetValue()))
+			}
+			dataPoints[domain] = append(dataPoints[domain], metricdata.DataPoint[float64]{
+				Attributes: attribute.NewSet(attributes...),
+				Time:       now,
+				Value:      metric.GetGauge().GetValue(),
+			})
+		}
+		for domain, points := range dataPoints {
+			domainMetrics[domain] = append(domainMetrics[domain], metricdata.Metrics{
+				Name:        metricFamily.GetName(),
+				Description: metricFamily.GetHelp(),
+				Data:        metricdata.Gauge[float64]{DataPoints: points},
+			})
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var lastErr error
+	for domain, metrics := range domainMetrics {
+		res := resource.NewSchemaless(
+			attribute.String("service.name", "upyun-exporter"),
+			attribute.String("upyun.account", s.config.Account),
+			attribute.String("upyun.bucket", s.domainBucket(domain)),
+			attribute.String("upyun.domain", domain),
+		)
+		err := s.exporter.Export(ctx, &metricdata.ResourceMetrics{
+			Resource: res,
+			ScopeMetrics: []metricdata.ScopeMetrics{{
+				Scope:   instrumentation.Scope{Name: "upyun-exporter"},
+				Metrics: metrics,
+			}},
+		})
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
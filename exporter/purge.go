@@ -0,0 +1,119 @@
+package exporter
+
+import (
+	"errors"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"upyun-exporter/httpRequest"
+	"upyun-exporter/logging"
+)
+
+// 刷新/预热配额按天计算, 任务历史同样取最近一天
+const purgeTaskRangeTime = 86400
+
+var purgeTaskTypes = []string{"purge", "prefetch"}
+
+// PurgeTaskExporter 导出刷新/预热任务状态及剩余配额, 抓取时实时请求
+type PurgeTaskExporter struct {
+	token                 *httpRequest.Token
+	cdnTaskCount          *prometheus.Desc
+	cdnTaskQuotaRemaining *prometheus.Desc
+	cdnTaskQuotaLimit     *prometheus.Desc
+}
+
+func CdnPurgeTaskExporter(token *httpRequest.Token) *PurgeTaskExporter {
+	return &PurgeTaskExporter{
+		token: token,
+
+		cdnTaskCount: prometheus.NewDesc(
+			prometheus.BuildFQName(cdnNameSpace, "cdn", "task_count"),
+			"最近一天刷新/预热任务数",
+			[]string{
+				"type",
+				"status",
+			},
+			nil,
+		),
+		cdnTaskQuotaRemaining: prometheus.NewDesc(
+			prometheus.BuildFQName(cdnNameSpace, "cdn", "task_quota_remaining"),
+			"刷新/预热今日剩余配额",
+			[]string{
+				"type",
+			},
+			nil,
+		),
+		cdnTaskQuotaLimit: prometheus.NewDesc(
+			prometheus.BuildFQName(cdnNameSpace, "cdn", "task_quota_limit"),
+			"刷新/预热每日配额",
+			[]string{
+				"type",
+			},
+			nil,
+		),
+	}
+}
+
+// normalizeTaskStatus 将接口返回的任务状态归为 success, pending, failed 三类
+func normalizeTaskStatus(status string) string {
+	switch status {
+	case "success", "succeed", "done":
+		return "success"
+	case "failed", "fail", "error":
+		return "failed"
+	default:
+		return "pending"
+	}
+}
+
+func (e *PurgeTaskExporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- e.cdnTaskCount
+	ch <- e.cdnTaskQuotaRemaining
+	ch <- e.cdnTaskQuotaLimit
+}
+
+// Update 查询刷新/预热任务及配额, 返回查询中的错误
+func (e *PurgeTaskExporter) Update(ch chan<- prometheus.Metric) error {
+	var errs []error
+	for _, taskType := range purgeTaskTypes {
+		taskList, err := httpRequest.DoPurgeTaskRequest(e.token, taskType, purgeTaskRangeTime)
+		if err != nil {
+			level.Warn(logging.Logger).Log("msg", "Failed to get task list", "type", taskType, "err", err)
+			errs = append(errs, err)
+			continue
+		}
+		counts := map[string]float64{"success": 0, "pending": 0, "failed": 0}
+		for _, task := range taskList.Result {
+			counts[normalizeTaskStatus(task.Status)]++
+		}
+		for status, count := range counts {
+			ch <- prometheus.MustNewConstMetric(
+				e.cdnTaskCount,
+				prometheus.GaugeValue,
+				count,
+				taskType,
+				status,
+			)
+		}
+	}
+
+	quota, err := httpRequest.DoPurgeQuotaRequest(e.token)
+	if err != nil {
+		level.Warn(logging.Logger).Log("msg", "Failed to get purge quota", "err", err)
+		return errors.Join(append(errs, err)...)
+	}
+	for taskType, item := range map[string]httpRequest.PurgeQuotaItem{"purge": quota.Purge, "prefetch": quota.Prefetch} {
+		ch <- prometheus.MustNewConstMetric(
+			e.cdnTaskQuotaRemaining,
+			prometheus.GaugeValue,
+			item.Remaining,
+			taskType,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			e.cdnTaskQuotaLimit,
+			prometheus.GaugeValue,
+			item.Limit,
+			taskType,
+		)
+	}
+	return errors.Join(errs...)
+}
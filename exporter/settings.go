@@ -0,0 +1,178 @@
+package exporter
+
+import (
+	"fmt"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+	"os"
+	"path"
+	"sort"
+	"time"
+	"upyun-exporter/httpRequest"
+)
+
+// DomainRule 按域名或空间名匹配的采集设置, 匹配规则同 path.Match, 为空时匹配所有, 未设置的项使用默认值
+type DomainRule struct {
+	Domain       string         `yaml:"domain"`
+	Bucket       string         `yaml:"bucket"`
+	WindowLength time.Duration  `yaml:"window_length"`
+	WindowLag    *time.Duration `yaml:"window_lag"`
+	// 大于 0 时, 距上次成功采集不足该间隔的抓取直接返回上次的结果
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	// 采集的 collector, 为空时采集所有启用的 collector
+	Metrics []string `yaml:"metrics"`
+	// 附加到该域名所有 cdn 指标上的 label
+	Labels map[string]string `yaml:"labels"`
+}
+
+func (r DomainRule) matches(domain string, bucket string) bool {
+	if r.Domain != "" {
+		if ok, _ := path.Match(r.Domain, domain); !ok {
+			return false
+		}
+	}
+	if r.Bucket != "" {
+		if ok, _ := path.Match(r.Bucket, bucket); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (r DomainRule) validate() error {
+	for _, pattern := range []string{r.Domain, r.Bucket} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	if r.WindowLength < 0 || r.RefreshInterval < 0 {
+		return fmt.Errorf("window_length and refresh_interval must not be negative")
+	}
+	if r.WindowLag != nil && *r.WindowLag < 0 {
+		return fmt.Errorf("window_lag must not be negative")
+	}
+	for _, name := range r.Metrics {
+		if !isCdnCollector(name) {
+			return fmt.Errorf("unknown collector %q, must be one of %v", name, cdnCollectorNames())
+		}
+	}
+	for name := range r.Labels {
+		if !model.LabelName(name).IsValid() || name == "instanceId" || name == "status" {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+	}
+	return nil
+}
+
+// settingsFile --config.file 的格式
+type settingsFile struct {
+	Domains []DomainRule `yaml:"domains"`
+}
+
+// LoadDomainRules 读取并校验配置文件中的域名规则
+func LoadDomainRules(file string) ([]DomainRule, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var settings settingsFile
+	if err := yaml.UnmarshalStrict(content, &settings); err != nil {
+		return nil, err
+	}
+	for i, rule := range settings.Domains {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("domains[%d]: %v", i, err)
+		}
+	}
+	return settings.Domains, nil
+}
+
+// DomainSettings 单个域名实际使用的采集设置
+type DomainSettings struct {
+	Window          httpRequest.WindowSpec
+	RefreshInterval time.Duration
+	Metrics         []string
+	Labels          map[string]string
+}
+
+// Enabled 返回域名是否采集 collector 对应的数据
+func (s DomainSettings) Enabled(collector string) bool {
+	if len(s.Metrics) == 0 {
+		return true
+	}
+	for _, enabled := range s.Metrics {
+		if enabled == collector {
+			return true
+		}
+	}
+	return false
+}
+
+// Settings cdn 采集设置. 域名使用 Rules 中第一条匹配的规则覆盖默认设置,
+// Windows 中单独指定的窗口长度或延迟逐项覆盖规则的结果
+type Settings struct {
+	Windows WindowConfig
+	Rules   []DomainRule
+	// 启用的 collector, 为 nil 时使用各 collector 的默认设置. 其中不是 cdn collector 的由 CollectorSet 采集
+	Collectors []string
+	// 返回域名所属的空间名, 用于匹配规则中的 bucket
+	DomainBucket func(domain string) string
+}
+
+func (s *Settings) For(domain string) DomainSettings {
+	settings := DomainSettings{Window: s.Windows.Default}
+	var bucket string
+	if s.DomainBucket != nil {
+		bucket = s.DomainBucket(domain)
+	}
+	for _, rule := range s.Rules {
+		if !rule.matches(domain, bucket) {
+			continue
+		}
+		if rule.WindowLength > 0 {
+			settings.Window.Length = rule.WindowLength
+		}
+		if rule.WindowLag != nil {
+			settings.Window.Lag = *rule.WindowLag
+		}
+		settings.RefreshInterval = rule.RefreshInterval
+		settings.Metrics = rule.Metrics
+		settings.Labels = rule.Labels
+		break
+	}
+	settings.Window = s.Windows.Override(domain, settings.Window)
+	return settings
+}
+
+// labelNames 返回所有规则中出现的附加 label, 所有 cdn 指标都带有这些 label, 未设置的域名值为空
+func (s *Settings) labelNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, rule := range s.Rules {
+		for name := range rule.Labels {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// enabledCollectors 返回启用的 cdn collector, 按名称排序
+func (s *Settings) enabledCollectors() []string {
+	var names []string
+	for _, name := range cdnCollectorNames() {
+		if s.Collectors == nil && CollectorDefaultEnabled(name) {
+			names = append(names, name)
+		}
+	}
+	for _, name := range s.Collectors {
+		if isCdnCollector(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
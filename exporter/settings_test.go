@@ -0,0 +1,55 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+	"upyun-exporter/httpRequest"
+)
+
+func TestSettingsFor(t *testing.T) {
+	lag := time.Minute
+	zero := time.Duration(0)
+	settings := &Settings{
+		Windows: WindowConfig{
+			Default: httpRequest.WindowSpec{Length: 25 * time.Minute, Lag: 5 * time.Minute},
+			Lengths: map[string]time.Duration{"override.a.com": 2 * time.Minute},
+			Lags:    map[string]time.Duration{"override.a.com": 30 * time.Second, "lag.b.com": 0},
+		},
+		Rules: []DomainRule{
+			{Domain: "img.*.com", WindowLength: 10 * time.Minute, RefreshInterval: time.Minute},
+			{Domain: "*.a.com", WindowLength: 5 * time.Minute, WindowLag: &lag},
+			{Bucket: "static-*", WindowLag: &zero, Metrics: []string{CollectorBandwidth}},
+			{Domain: "*.com", WindowLength: time.Hour},
+		},
+		DomainBucket: func(domain string) string {
+			if domain == "lag.b.com" || domain == "s.b.com" {
+				return "static-b"
+			}
+			return "bucket"
+		},
+	}
+	tests := []struct {
+		domain          string
+		window          httpRequest.WindowSpec
+		refreshInterval time.Duration
+		metrics         int
+	}{
+		// 先匹配的规则生效, 后面的 *.a.com 及 *.com 不再应用
+		{"img.a.com", httpRequest.WindowSpec{Length: 10 * time.Minute, Lag: 5 * time.Minute}, time.Minute, 0},
+		{"www.a.com", httpRequest.WindowSpec{Length: 5 * time.Minute, Lag: time.Minute}, 0, 0},
+		// 按空间匹配, 延迟设置为 0 也生效
+		{"s.b.com", httpRequest.WindowSpec{Length: 25 * time.Minute}, 0, 1},
+		{"c.com", httpRequest.WindowSpec{Length: time.Hour, Lag: 5 * time.Minute}, 0, 0},
+		{"c.org", httpRequest.WindowSpec{Length: 25 * time.Minute, Lag: 5 * time.Minute}, 0, 0},
+		// 参数的长度及延迟逐项覆盖规则
+		{"override.a.com", httpRequest.WindowSpec{Length: 2 * time.Minute, Lag: 30 * time.Second}, 0, 0},
+		{"lag.b.com", httpRequest.WindowSpec{Length: 25 * time.Minute}, 0, 1},
+	}
+	for _, tt := range tests {
+		got := settings.For(tt.domain)
+		if got.Window != tt.window || got.RefreshInterval != tt.refreshInterval || len(got.Metrics) != tt.metrics {
+			t.Errorf("For(%q) = {%+v, %s, %v}, want {%+v, %s, %d metrics}",
+				tt.domain, got.Window, got.RefreshInterval, got.Metrics, tt.window, tt.refreshInterval, tt.metrics)
+		}
+	}
+}
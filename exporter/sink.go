@@ -0,0 +1,231 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sink 接收每次 Collect 的采集结果, 用于将同一份数据输出到 Prometheus 以外的系统
+type Sink interface {
+	Name() string
+	Write(snapshots []DomainSnapshot) error
+}
+
+// sample 由 DomainSnapshot 展开的单个数值, group 为 cdn 或 backsource
+type sample struct {
+	group  string
+	field  string
+	status string
+	value  float64
+}
+
+// samples 将采集结果展开为扁平的数值列表, 状态码比例的 field 为 status_rate,
+// NaN 及 Inf 等 sink 无法写入的数值被丢弃
+func (s DomainSnapshot) samples() []sample {
+	var samples []sample
+	if s.BandWidth != nil {
+		samples = append(samples,
+			sample{group: "cdn", field: "bandwidth", value: s.BandWidth.BandWidth},
+			sample{group: "cdn", field: "request_count", value: s.BandWidth.RequestCount},
+		)
+	}
+	if s.Flow != nil {
+		samples = append(samples,
+			sample{group: "cdn", field: "hit_rate", value: s.Flow.HitRate},
+			sample{group: "cdn", field: "flux_hit_rate", value: s.Flow.FluxHitRate},
+		)
+		samples = append(samples, statusSamples("cdn", s.Flow.StatusRate)...)
+	}
+	if s.BackSource != nil {
+		samples = append(samples,
+			sample{group: "backsource", field: "bandwidth", value: s.BackSource.BandWidth},
+			sample{group: "backsource", field: "request_count", value: s.BackSource.RequestCount},
+		)
+		samples = append(samples, statusSamples("backsource", s.BackSource.StatusRate)...)
+	}
+	finite := samples[:0]
+	for _, sample := range samples {
+		if !math.IsNaN(sample.value) && !math.IsInf(sample.value, 0) {
+			finite = append(finite, sample)
+		}
+	}
+	return finite
+}
+
+func statusSamples(group string, statusRate map[string]float64) []sample {
+	statuses := make([]string, 0, len(statusRate))
+	for status := range statusRate {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	samples := make([]sample, 0, len(statuses))
+	for _, status := range statuses {
+		samples = append(samples, sample{group: group, field: "status_rate", status: status, value: statusRate[status]})
+	}
+	return samples
+}
+
+// InfluxSink 以 InfluxDB line protocol 通过 HTTP 写入, URL 为完整的写入地址,
+// 如 http://influxdb:8086/write?db=upyun 或 http://influxdb:8086/api/v2/write?org=o&bucket=b
+type InfluxSink struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func NewInfluxSink(url string, token string) *InfluxSink {
+	return &InfluxSink{
+		url:    url,
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *InfluxSink) Name() string {
+	return "influxdb"
+}
+
+// influxEscape 转义 tag 中的逗号, 等号及空格
+var influxEscape = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// influxLines 生成 line protocol, 每个域名一行 upyun_<group>, 状态码比例单独为 upyun_<group>_status
+func influxLines(snapshots []DomainSnapshot) []byte {
+	var buf bytes.Buffer
+	for _, snapshot := range snapshots {
+		fields := make(map[string][]string)
+		var groups []string
+		for _, sample := range snapshot.samples() {
+			measurement := cdnNameSpace + "_" + sample.group
+			tags := ",domain=" + influxEscape.Replace(snapshot.Domain)
+			field := sample.field + "=" + strconv.FormatFloat(sample.value, 'f', -1, 64)
+			if sample.status != "" {
+				measurement += "_status"
+				tags += ",status=" + influxEscape.Replace(sample.status)
+				field = "rate=" + strconv.FormatFloat(sample.value, 'f', -1, 64)
+			}
+			key := measurement + tags
+			if _, ok := fields[key]; !ok {
+				groups = append(groups, key)
+			}
+			fields[key] = append(fields[key], field)
+		}
+		for _, key := range groups {
+			fmt.Fprintf(&buf, "%s %s %d\n", key, strings.Join(fields[key], ","), snapshot.Time.UnixNano())
+		}
+	}
+	return buf.Bytes()
+}
+
+func (s *InfluxSink) Write(snapshots []DomainSnapshot) error {
+	body := influxLines(snapshots)
+	if len(body) == 0 {
+		return nil
+	}
+	request, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		request.Header.Set("Authorization", "Token "+s.token)
+	}
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("influxdb returned %d: %s", response.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// metricPath 生成 <prefix>.<domain>.<group>.<field>[.<status>] 形式的名称, 域名中的 . 替换为 _
+func metricPath(prefix string, domain string, sample sample) string {
+	path := []string{prefix, strings.ReplaceAll(domain, ".", "_"), sample.group, sample.field}
+	if sample.status != "" {
+		path = append(path, sample.status)
+	}
+	return strings.Join(path, ".")
+}
+
+// StatsdSink 以 StatsD gauge 通过 UDP 发送
+type StatsdSink struct {
+	address string
+	prefix  string
+}
+
+func NewStatsdSink(address string, prefix string) *StatsdSink {
+	return &StatsdSink{address: address, prefix: prefix}
+}
+
+func (s *StatsdSink) Name() string {
+	return "statsd"
+}
+
+func (s *StatsdSink) Write(snapshots []DomainSnapshot) error {
+	conn, err := net.DialTimeout("udp", s.address, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// 每个域名一个数据包, 避免超过 UDP 包大小
+	for _, snapshot := range snapshots {
+		var buf bytes.Buffer
+		for _, sample := range snapshot.samples() {
+			fmt.Fprintf(&buf, "%s:%s|g\n", metricPath(s.prefix, snapshot.Domain, sample),
+				strconv.FormatFloat(sample.value, 'f', -1, 64))
+		}
+		if buf.Len() == 0 {
+			continue
+		}
+		if _, err := conn.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GraphiteSink 以 Graphite plaintext 协议通过 TCP 发送
+type GraphiteSink struct {
+	address string
+	prefix  string
+}
+
+func NewGraphiteSink(address string, prefix string) *GraphiteSink {
+	return &GraphiteSink{address: address, prefix: prefix}
+}
+
+func (s *GraphiteSink) Name() string {
+	return "graphite"
+}
+
+func (s *GraphiteSink) Write(snapshots []DomainSnapshot) error {
+	var buf bytes.Buffer
+	for _, snapshot := range snapshots {
+		for _, sample := range snapshot.samples() {
+			fmt.Fprintf(&buf, "%s %s %d\n", metricPath(s.prefix, snapshot.Domain, sample),
+				strconv.FormatFloat(sample.value, 'f', -1, 64), snapshot.Time.Unix())
+		}
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	conn, err := net.DialTimeout("tcp", s.address, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err = conn.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,88 @@
+package exporter
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestInfluxLines(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name      string
+		snapshots []DomainSnapshot
+		want      string
+	}{
+		{
+			name:      "empty snapshot",
+			snapshots: []DomainSnapshot{{Domain: "a.com", Time: now}},
+			want:      "",
+		},
+		{
+			name: "bandwidth fields grouped in one line",
+			snapshots: []DomainSnapshot{{
+				Domain:    "a.com",
+				Time:      now,
+				BandWidth: &BandWidthStats{RequestCount: 120, BandWidth: 1.5},
+			}},
+			want: "upyun_cdn,domain=a.com bandwidth=1.5,request_count=120 1700000000000000000\n",
+		},
+		{
+			name: "flow and status rate in separate measurements",
+			snapshots: []DomainSnapshot{{
+				Domain:    "a.com",
+				Time:      now,
+				BandWidth: &BandWidthStats{RequestCount: 120, BandWidth: 1.5},
+				Flow: &FlowStats{HitRate: 90, FluxHitRate: 80, StatusRate: map[string]float64{
+					"404": 1, "200": 99,
+				}},
+			}},
+			want: "upyun_cdn,domain=a.com bandwidth=1.5,request_count=120,hit_rate=90,flux_hit_rate=80 1700000000000000000\n" +
+				"upyun_cdn_status,domain=a.com,status=200 rate=99 1700000000000000000\n" +
+				"upyun_cdn_status,domain=a.com,status=404 rate=1 1700000000000000000\n",
+		},
+		{
+			name: "backsource group",
+			snapshots: []DomainSnapshot{{
+				Domain:     "a.com",
+				Time:       now,
+				BackSource: &BackSourceStats{BandWidth: 0.25, RequestCount: 3, StatusRate: map[string]float64{"502": 100}},
+			}},
+			want: "upyun_backsource,domain=a.com bandwidth=0.25,request_count=3 1700000000000000000\n" +
+				"upyun_backsource_status,domain=a.com,status=502 rate=100 1700000000000000000\n",
+		},
+		{
+			name: "tags escaped",
+			snapshots: []DomainSnapshot{{
+				Domain: "a b,c=d",
+				Time:   now,
+				Flow:   &FlowStats{HitRate: 1, FluxHitRate: 2, StatusRate: map[string]float64{"x y": 3}},
+			}},
+			want: `upyun_cdn,domain=a\ b\,c\=d hit_rate=1,flux_hit_rate=2 1700000000000000000` + "\n" +
+				`upyun_cdn_status,domain=a\ b\,c\=d,status=x\ y rate=3 1700000000000000000` + "\n",
+		},
+		{
+			name: "non-finite values dropped",
+			snapshots: []DomainSnapshot{{
+				Domain: "a.com",
+				Time:   now,
+				Flow:   &FlowStats{HitRate: math.NaN(), FluxHitRate: 2, StatusRate: map[string]float64{"200": math.Inf(1)}},
+			}},
+			want: "upyun_cdn,domain=a.com flux_hit_rate=2 1700000000000000000\n",
+		},
+		{
+			name: "one group per domain",
+			snapshots: []DomainSnapshot{
+				{Domain: "a.com", Time: now, BandWidth: &BandWidthStats{RequestCount: 1, BandWidth: 2}},
+				{Domain: "b.com", Time: now.Add(time.Second), BandWidth: &BandWidthStats{RequestCount: 3, BandWidth: 4}},
+			},
+			want: "upyun_cdn,domain=a.com bandwidth=2,request_count=1 1700000000000000000\n" +
+				"upyun_cdn,domain=b.com bandwidth=4,request_count=3 1700000001000000000\n",
+		},
+	}
+	for _, tt := range tests {
+		if got := string(influxLines(tt.snapshots)); got != tt.want {
+			t.Errorf("influxLines(%s) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package exporter
+
+import (
+	"sync"
+	"time"
+)
+
+// DomainStatus 单个域名最近一次采集到的各项数据及采集状态,
+// 某项数据本次未采集到时保留上一次的值, 对应的 *Time 为该值的采集时间
+type DomainStatus struct {
+	Domain string `json:"domain"`
+	Bucket string `json:"bucket,omitempty"`
+	// 最近一次采集时间
+	LastCollection *time.Time `json:"last_collection,omitempty"`
+	// 最近一次没有错误的采集时间
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	// 最近一次采集的错误信息, 成功时为空
+	LastError      string           `json:"last_error,omitempty"`
+	BandWidth      *BandWidthStats  `json:"bandwidth,omitempty"`
+	BandWidthTime  *time.Time       `json:"bandwidth_time,omitempty"`
+	Flow           *FlowStats       `json:"flow,omitempty"`
+	FlowTime       *time.Time       `json:"flow_time,omitempty"`
+	BackSource     *BackSourceStats `json:"backsource,omitempty"`
+	BackSourceTime *time.Time       `json:"backsource_time,omitempty"`
+}
+
+// SnapshotStore 作为 Sink 保存每个域名最近一次的采集结果, 供 API 及状态页使用
+type SnapshotStore struct {
+	mu      sync.RWMutex
+	domains map[string]DomainStatus
+}
+
+func NewSnapshotStore() *SnapshotStore {
+	return &SnapshotStore{domains: make(map[string]DomainStatus)}
+}
+
+func (s *SnapshotStore) Name() string {
+	return "snapshot"
+}
+
+func (s *SnapshotStore) Write(snapshots []DomainSnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, snapshot := range snapshots {
+		collected := snapshot.Time
+		status := s.domains[snapshot.Domain]
+		status.Domain = snapshot.Domain
+		status.LastCollection = &collected
+		status.LastError = snapshot.Error
+		if snapshot.Error == "" {
+			status.LastSuccess = &collected
+		}
+		if snapshot.BandWidth != nil {
+			status.BandWidth, status.BandWidthTime = snapshot.BandWidth, &collected
+		}
+		if snapshot.Flow != nil {
+			status.Flow, status.FlowTime = snapshot.Flow, &collected
+		}
+		if snapshot.BackSource != nil {
+			status.BackSource, status.BackSourceTime = snapshot.BackSource, &collected
+		}
+		s.domains[snapshot.Domain] = status
+	}
+	return nil
+}
+
+// Domain 返回单个域名的状态, 未采集过时返回 false
+func (s *SnapshotStore) Domain(domain string) (DomainStatus, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	status, ok := s.domains[domain]
+	return status, ok
+}
@@ -0,0 +1,170 @@
+package exporter
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+	"upyun-exporter/httpRequest"
+)
+
+// BandWidthStats 由带宽接口数据计算出的 cdn 请求数及带宽
+type BandWidthStats struct {
+	// 平均请求数(次/分钟)
+	RequestCount float64 `json:"request_count"`
+	// 平均带宽(Mbps)
+	BandWidth float64 `json:"bandwidth"`
+}
+
+// FlowStats 由 cdn 流量明细计算出的命中率及状态码比例, 单位均为 %
+type FlowStats struct {
+	HitRate     float64            `json:"hit_rate"`
+	FluxHitRate float64            `json:"flux_hit_rate"`
+	StatusRate  map[string]float64 `json:"status_rate"`
+}
+
+// BackSourceStats 由回源流量明细计算出的回源带宽, 请求数及状态码比例
+type BackSourceStats struct {
+	// 平均回源带宽(Mbps)
+	BandWidth float64 `json:"bandwidth"`
+	// 平均回源请求数(次/分钟)
+	RequestCount float64            `json:"request_count"`
+	StatusRate   map[string]float64 `json:"status_rate"`
+}
+
+// round3 保留 3 位小数
+func round3(value float64) float64 {
+	rounded, _ := strconv.ParseFloat(fmt.Sprintf("%.3f", value), 64)
+	return rounded
+}
+
+// CalculateBandWidthStats 计算平均请求数及带宽, 请求数或带宽为 0 时返回 false
+func CalculateBandWidthStats(data httpRequest.BandWidthList) (BandWidthStats, bool) {
+	var (
+		requestCountTotal float64
+		bandWidthTotal    float64
+	)
+	for _, point := range data.Data {
+		requestCountTotal += point.Reqs
+		bandWidthTotal += point.Bandwidth
+	}
+	// 去掉数据量为0的数据，得到的结果是NaN
+	if requestCountTotal == 0 || bandWidthTotal == 0 {
+		return BandWidthStats{}, false
+	}
+	return BandWidthStats{
+		RequestCount: calculateRequestCountPerMin(requestCountTotal/float64(len(data.Data)), data.IntervalDuration()),
+		BandWidth:    bandWidthTotal / float64(len(data.Data)) / 1000 / 1000,
+	}, true
+}
+
+// calculateStatusRate 计算各状态码及 2xx-5xx 占比(%), 没有状态码数据时返回空的结果
+func calculateStatusRate(data []httpRequest.FlowDetail) map[string]float64 {
+	var (
+		codeTotal    int
+		code200Total int
+		code206Total int
+		code301Total int
+		code302Total int
+		code304Total int
+		code400Total int
+		code403Total int
+		code404Total int
+		code411Total int
+		code499Total int
+		code500Total int
+		code502Total int
+		code503Total int
+		code504Total int
+	)
+	for _, point := range data {
+		code200Total += point.Code200
+		code206Total += point.Code206
+		code301Total += point.Code301
+		code302Total += point.Code302
+		code304Total += point.Code304
+		code400Total += point.Code400
+		code403Total += point.Code403
+		code404Total += point.Code404
+		code411Total += point.Code411
+		code499Total += point.Code499
+		code500Total += point.Code500
+		code502Total += point.Code502
+		code503Total += point.Code503
+		code504Total += point.Code504
+	}
+	codeTotal = code200Total + code206Total + code301Total + code302Total + code304Total + code400Total + code403Total +
+		code404Total + code411Total + code499Total + code500Total + code502Total + code503Total + code504Total
+	statusCodes := make(map[string]float64)
+	if codeTotal == 0 {
+		return statusCodes
+	}
+	statusCodes["200"] = float64(code200Total) / float64(codeTotal)
+	statusCodes["206"] = float64(code206Total) / float64(codeTotal)
+	statusCodes["2xx"] = float64(code200Total+code206Total) / float64(codeTotal)
+	statusCodes["301"] = float64(code301Total) / float64(codeTotal)
+	statusCodes["302"] = float64(code302Total) / float64(codeTotal)
+	statusCodes["304"] = float64(code304Total) / float64(codeTotal)
+	statusCodes["3xx"] = float64(code301Total+code302Total+code304Total) / float64(codeTotal)
+	statusCodes["400"] = float64(code400Total) / float64(codeTotal)
+	statusCodes["403"] = float64(code403Total) / float64(codeTotal)
+	statusCodes["404"] = float64(code404Total) / float64(codeTotal)
+	statusCodes["411"] = float64(code411Total) / float64(codeTotal)
+	statusCodes["499"] = float64(code499Total) / float64(codeTotal)
+	statusCodes["4xx"] = float64(code400Total+code403Total+code404Total+code411Total+code499Total) / float64(codeTotal)
+	statusCodes["500"] = float64(code500Total) / float64(codeTotal)
+	statusCodes["502"] = float64(code502Total) / float64(codeTotal)
+	statusCodes["503"] = float64(code503Total) / float64(codeTotal)
+	statusCodes["504"] = float64(code504Total) / float64(codeTotal)
+	statusCodes["5xx"] = float64(code500Total+code502Total+code503Total+code504Total) / float64(codeTotal)
+	for status, rate := range statusCodes {
+		statusCodes[status] = round3(rate * 100)
+	}
+	return statusCodes
+}
+
+// CalculateFlowStats 计算 cdn 缓存命中率, 字节命中率及状态码占比,
+// 请求数或流量为 0 的数据点不参与对应命中率的平均, 全部为 0 时命中率为 0
+func CalculateFlowStats(data []httpRequest.FlowDetail) FlowStats {
+	var (
+		cdnHitRateTotal      float64
+		cdnHitRatePoints     int
+		cdnFlowHitRateTotal  float64
+		cdnFlowHitRatePoints int
+	)
+	for _, point := range data {
+		if point.Reqs != 0 {
+			// FIXME: upyun treats 403 as not hit
+			cdnHitRateTotal = cdnHitRateTotal + (float64(point.Hit)+float64(point.Code403))/float64(point.Reqs)
+			cdnHitRatePoints++
+		}
+		if point.Bytes != 0 {
+			cdnFlowHitRateTotal = cdnFlowHitRateTotal + (float64(point.HitBytes) / float64(point.Bytes))
+			cdnFlowHitRatePoints++
+		}
+	}
+	stats := FlowStats{StatusRate: calculateStatusRate(data)}
+	if cdnHitRatePoints > 0 {
+		stats.HitRate = round3((cdnHitRateTotal / float64(cdnHitRatePoints)) * 100)
+	}
+	if cdnFlowHitRatePoints > 0 {
+		stats.FluxHitRate = round3((cdnFlowHitRateTotal / float64(cdnFlowHitRatePoints)) * 100)
+	}
+	return stats
+}
+
+// CalculateBackSourceStats 计算平均回源带宽, 回源请求数及回源状态码占比, interval 为数据的聚合粒度
+func CalculateBackSourceStats(data []httpRequest.FlowDetail, interval time.Duration) BackSourceStats {
+	var (
+		resourceBandwidthTotal float64
+		resourceReqsTotal      int
+	)
+	for _, point := range data {
+		resourceBandwidthTotal += point.Bandwidth
+		resourceReqsTotal += point.Reqs
+	}
+	return BackSourceStats{
+		BandWidth:    resourceBandwidthTotal / float64(len(data)) / 1000 / 1000,
+		RequestCount: calculateRequestCountPerMin(float64(resourceReqsTotal)/float64(len(data)), interval),
+		StatusRate:   calculateStatusRate(data),
+	}
+}
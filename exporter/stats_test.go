@@ -0,0 +1,48 @@
+package exporter
+
+import (
+	"testing"
+	"upyun-exporter/httpRequest"
+)
+
+func TestCalculateFlowStats(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []httpRequest.FlowDetail
+		hitRate     float64
+		fluxHitRate float64
+		statusRate  map[string]float64
+	}{
+		{
+			name:        "zero requests and bytes",
+			data:        []httpRequest.FlowDetail{{}, {}},
+			hitRate:     0,
+			fluxHitRate: 0,
+			statusRate:  map[string]float64{},
+		},
+		{
+			name: "zero points skipped",
+			data: []httpRequest.FlowDetail{
+				{Reqs: 4, Hit: 3, Bytes: 10, HitBytes: 5, Code200: 3, Code404: 1},
+				{},
+			},
+			hitRate:     75,
+			fluxHitRate: 50,
+			statusRate:  map[string]float64{"200": 75, "404": 25, "2xx": 75, "4xx": 25},
+		},
+	}
+	for _, tt := range tests {
+		got := CalculateFlowStats(tt.data)
+		if got.HitRate != tt.hitRate || got.FluxHitRate != tt.fluxHitRate {
+			t.Errorf("CalculateFlowStats(%s) = {%v, %v}, want {%v, %v}", tt.name, got.HitRate, got.FluxHitRate, tt.hitRate, tt.fluxHitRate)
+		}
+		for status, rate := range got.StatusRate {
+			if want := tt.statusRate[status]; rate != want {
+				t.Errorf("CalculateFlowStats(%s).StatusRate[%q] = %v, want %v", tt.name, status, rate, want)
+			}
+		}
+		if len(tt.statusRate) == 0 && len(got.StatusRate) != 0 {
+			t.Errorf("CalculateFlowStats(%s).StatusRate = %v, want empty", tt.name, got.StatusRate)
+		}
+	}
+}
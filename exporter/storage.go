@@ -0,0 +1,92 @@
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"upyun-exporter/httpRequest"
+)
+
+// StorageExporter 导出空间存储用量, 数据由 SetUsage 按较慢的间隔刷新, 抓取时只读缓存
+type StorageExporter struct {
+	mu    sync.RWMutex
+	usage map[string]httpRequest.BucketUsage
+	// 最近一次刷新中的错误
+	err                     error
+	bucketStorage           *prometheus.Desc
+	bucketObjectCount       *prometheus.Desc
+	bucketInfrequentStorage *prometheus.Desc
+}
+
+func BucketStorageExporter() *StorageExporter {
+	return &StorageExporter{
+		usage: make(map[string]httpRequest.BucketUsage),
+
+		bucketStorage: prometheus.NewDesc(
+			prometheus.BuildFQName(cdnNameSpace, "bucket", "storage_bytes"),
+			"空间存储用量(字节)",
+			[]string{
+				"bucket",
+			},
+			nil,
+		),
+		bucketObjectCount: prometheus.NewDesc(
+			prometheus.BuildFQName(cdnNameSpace, "bucket", "object_count"),
+			"空间文件数",
+			[]string{
+				"bucket",
+			},
+			nil,
+		),
+		bucketInfrequentStorage: prometheus.NewDesc(
+			prometheus.BuildFQName(cdnNameSpace, "bucket", "infrequent_storage_bytes"),
+			"空间低频存储用量(字节)",
+			[]string{
+				"bucket",
+			},
+			nil,
+		),
+	}
+}
+
+// SetUsage 替换缓存的空间用量, err 为刷新中部分空间查询失败的错误
+func (e *StorageExporter) SetUsage(usage map[string]httpRequest.BucketUsage, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.usage = usage
+	e.err = err
+}
+
+func (e *StorageExporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- e.bucketStorage
+	ch <- e.bucketObjectCount
+	ch <- e.bucketInfrequentStorage
+}
+
+// Update 输出缓存的空间用量, 返回最近一次刷新中的错误
+func (e *StorageExporter) Update(ch chan<- prometheus.Metric) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for bucket, usage := range e.usage {
+		ch <- prometheus.MustNewConstMetric(
+			e.bucketStorage,
+			prometheus.GaugeValue,
+			usage.Storage,
+			bucket,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			e.bucketInfrequentStorage,
+			prometheus.GaugeValue,
+			usage.InfrequentStorage,
+			bucket,
+		)
+		if usage.Files != nil {
+			ch <- prometheus.MustNewConstMetric(
+				e.bucketObjectCount,
+				prometheus.GaugeValue,
+				*usage.Files,
+				bucket,
+			)
+		}
+	}
+	return e.err
+}
@@ -0,0 +1,56 @@
+package httpRequest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+const accessLogListAddress = "https://api.upyun.com/analysis/archives"
+
+type AccessLogList struct {
+	Data []struct {
+		Name string  `json:"name"`
+		Url  string  `json:"url"`
+		Size float64 `json:"size"`
+	} `json:"data"`
+}
+
+// DoAccessLogListRequest 获取域名某天的访问日志文件列表, date 格式为 2006-01-02
+func DoAccessLogListRequest(domain string, token *Token, date string) (AccessLogList, *ApiError) {
+	var logList AccessLogList
+	params := make(url.Values)
+	params.Add("domain", domain)
+	params.Add("date", date)
+	body, apiErr := doGetRequest(accessLogListAddress, params, token)
+	if apiErr != nil {
+		return logList, apiErr
+	}
+
+	err := json.Unmarshal(body, &logList)
+	if err != nil {
+		return logList, NewRequestError(fmt.Sprintf("Failed to decode body to access log list, domain: %s, response: %s, error: %v",
+			domain, string(body), err), ParseError)
+	}
+	return logList, nil
+}
+
+// DownloadAccessLog 下载日志文件, 下载地址已签名, 不需要 token, 调用方负责关闭返回的 body
+func DownloadAccessLog(address string) (io.ReadCloser, *ApiError) {
+	req, err := http.NewRequestWithContext(requestContext, "GET", address, nil)
+	if err != nil {
+		return nil, NewRequestError(fmt.Sprintf("failed to create request, error: %v", err), RequestFailed)
+	}
+	response, err := client.Do(req)
+	if err != nil {
+		return nil, NewRequestError(fmt.Sprintf("failed to download access log, error: %v", err), RequestFailed)
+	}
+	if response.StatusCode != 200 {
+		response.Body.Close()
+		return nil, NewRequestError(fmt.Sprintf("failed to download access log, return code not 200, response code: %v",
+			response.StatusCode), ResponseCodeNot200)
+	}
+	return response.Body, nil
+}
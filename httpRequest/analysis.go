@@ -0,0 +1,40 @@
+package httpRequest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const topAnalysisAddress = "https://api.upyun.com/analysis"
+
+type TopAnalysis struct {
+	Data []struct {
+		Name  string  `json:"name"`
+		Reqs  float64 `json:"reqs"`
+		Bytes float64 `json:"bytes"`
+	} `json:"data"`
+}
+
+// DoTopAnalysisRequest 获取域名当天的 top N 统计, analysisType 为 url, referer, ip 或 ua
+func DoTopAnalysisRequest(domain string, token *Token, analysisType string, limit int) (TopAnalysis, *ApiError) {
+	var analysis TopAnalysis
+	params := make(url.Values)
+	params.Add("domain", domain)
+	params.Add("date", FormatDate(time.Now()))
+	params.Add("type", analysisType)
+	params.Add("limit", strconv.Itoa(limit))
+	body, apiErr := doGetRequest(topAnalysisAddress, params, token)
+	if apiErr != nil {
+		return analysis, apiErr
+	}
+
+	err := json.Unmarshal(body, &analysis)
+	if err != nil {
+		return analysis, NewRequestError(fmt.Sprintf("Failed to decode body to top %s analysis, domain: %s, response: %s, error: %v",
+			analysisType, domain, string(body), err), ParseError)
+	}
+	return analysis, nil
+}
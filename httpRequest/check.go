@@ -0,0 +1,154 @@
+package httpRequest
+
+import (
+	"encoding/json"
+	"net/url"
+	"time"
+)
+
+// CheckResult 单个接口的检查结果
+type CheckResult struct {
+	Endpoint string
+	// 用到该接口的 collector, 为空时 exporter 始终会用到
+	Collector  string
+	StatusCode int
+	Err        *ApiError
+	// 缺少空间, 域名或 collector 未启用而未检查
+	Skipped bool
+}
+
+// CheckEndpoints 依次请求 exporter 用到的接口, 检查 token 是否有效及是否有对应权限.
+// bucket 或 domain 为空时与 exporter 一样从可见空间及其 cdn 域名中选取第一个.
+// enabled 返回 collector 是否启用, 名称与 exporter 的 collector 相同, 未启用的 collector 用到的接口不检查
+func CheckEndpoints(token *Token, bucketToken *Token, bucket string, domain string, enabled func(collector string) bool) []CheckResult {
+	var results []CheckResult
+	record := func(address string, collector string, err *ApiError) {
+		result := CheckResult{Endpoint: address, Collector: collector, StatusCode: 200}
+		// 返回 200 但内容无法解析时仍说明 token 有权限访问该接口
+		if err != nil && err.T != ParseError {
+			result.StatusCode = err.StatusCode
+			result.Err = err
+		}
+		results = append(results, result)
+	}
+	skip := func(address string, collector string, reason string) {
+		results = append(results, CheckResult{Endpoint: address, Collector: collector, Err: NewRequestError(reason, RequestFailed), Skipped: true})
+	}
+
+	params := make(url.Values)
+	params.Add("business_type", "file")
+	params.Add("type", "ucdn")
+	body, err := doGetRequest(domainListAddress, params, bucketToken)
+	record(domainListAddress, "", err)
+	var bucketList BucketList
+	if err == nil && json.Unmarshal(body, &bucketList) == nil {
+		for _, item := range bucketList.Buckets {
+			domains := cdnDomains(item.Domains)
+			needBucket := bucket == ""
+			needDomain := domain == "" && len(domains) > 0
+			// 与 exporter 一样跳过不可见的空间
+			if !needBucket && !needDomain || !checkBucketVisible(item.BucketName, bucketToken) {
+				continue
+			}
+			if needBucket {
+				bucket = item.BucketName
+			}
+			if needDomain {
+				domain = domains[0]
+			}
+		}
+	}
+
+	if bucket == "" {
+		skip(bucketInfoAddress, "", "no bucket available, use --bucket to specify one")
+		skip(bucketUsageAddress, "storage", "no bucket available, use --bucket to specify one")
+	} else {
+		params = make(url.Values)
+		params.Add("bucket_name", bucket)
+		_, err = doGetRequest(bucketInfoAddress, params, bucketToken)
+		record(bucketInfoAddress, "", err)
+		if enabled("storage") {
+			_, err = DoBucketUsageRequest(bucket, bucketToken)
+			record(bucketUsageAddress, "storage", err)
+		} else {
+			skip(bucketUsageAddress, "storage", "collector.storage is disabled")
+		}
+	}
+
+	if enabled("purge") {
+		// 与 exporter 一样查询最近一天的任务
+		_, err = DoPurgeTaskRequest(token, "purge", 86400)
+		record(purgeTaskAddress, "purge", err)
+		_, err = DoPurgeTaskRequest(token, "prefetch", 86400)
+		record(prefetchTaskAddress, "purge", err)
+		_, err = DoPurgeQuotaRequest(token)
+		record(purgeQuotaAddress, "purge", err)
+	} else {
+		for _, address := range []string{purgeTaskAddress, prefetchTaskAddress, purgeQuotaAddress} {
+			skip(address, "purge", "collector.purge is disabled")
+		}
+	}
+
+	if domain == "" {
+		reason := "no domain available, use --domain to specify one"
+		skip(httpBandWidthAddress, "", reason)
+		skip(httpBandWidthDetailAddress, "", reason)
+		skip(httpsManagerAddress, "certificate", reason)
+		skip(certificateInfoAddress, "certificate", reason)
+		skip(topAnalysisAddress, "top", reason)
+		skip(accessLogListAddress, "accesslog", reason)
+		return results
+	}
+	window := LastWindow(time.Now(), 30*time.Minute)
+	_, err = DoHttpBandWidthRequest(domain, token, window)
+	record(httpBandWidthAddress, "", err)
+	_, err = DoHttpFlowDetailRequest(domain, token, window, "cdn")
+	record(httpBandWidthDetailAddress, "", err)
+
+	if enabled("certificate") {
+		manager, err := DoHttpsManagerRequest(domain, bucketToken)
+		record(httpsManagerAddress, "certificate", err)
+		var certificateId string
+		for _, item := range manager.Data.Domains {
+			if item.Name == domain {
+				certificateId = item.CertificateId
+			}
+		}
+		if certificateId == "" {
+			skip(certificateInfoAddress, "certificate", "domain "+domain+" has no certificate")
+		} else {
+			_, err = DoCertificateInfoRequest(certificateId, bucketToken)
+			record(certificateInfoAddress, "certificate", err)
+		}
+	} else {
+		skip(httpsManagerAddress, "certificate", "collector.certificate is disabled")
+		skip(certificateInfoAddress, "certificate", "collector.certificate is disabled")
+	}
+
+	if enabled("top") {
+		_, err = DoTopAnalysisRequest(domain, token, "url", 1)
+		record(topAnalysisAddress, "top", err)
+	} else {
+		skip(topAnalysisAddress, "top", "collector.top is disabled")
+	}
+
+	if enabled("accesslog") {
+		_, err = DoAccessLogListRequest(domain, token, FormatDate(time.Now()))
+		record(accessLogListAddress, "accesslog", err)
+	} else {
+		skip(accessLogListAddress, "accesslog", "collector.accesslog is disabled")
+	}
+	return results
+}
+
+// checkBucketVisible 返回空间是否可见, 查询失败时返回 false
+func checkBucketVisible(bucket string, token *Token) bool {
+	params := make(url.Values)
+	params.Add("bucket_name", bucket)
+	body, err := doGetRequest(bucketInfoAddress, params, token)
+	if err != nil {
+		return false
+	}
+	var bucketInfo BucketInfo
+	return json.Unmarshal(body, &bucketInfo) == nil && bucketInfo.Visible
+}
@@ -0,0 +1,95 @@
+package httpRequest
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kit/log/level"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+	"upyun-exporter/logging"
+)
+
+var (
+	client = &http.Client{}
+	// 所有 UpYun 请求共用的 context, 退出时通过 CancelRequests 取消进行中的请求
+	requestContext, cancelRequests = context.WithCancel(context.Background())
+)
+
+// CancelRequests 取消所有进行中的请求, 之后发出的请求也会立即失败
+func CancelRequests() {
+	cancelRequests()
+}
+
+// Canceled 请求是否已被 CancelRequests 取消
+func Canceled() bool {
+	return requestContext.Err() != nil
+}
+
+// requestDomain 从请求参数中取出域名或空间名, 用于日志
+func requestDomain(params url.Values) string {
+	for _, key := range []string{"domain", "query_value", "bucket_name"} {
+		if value := params.Get(key); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// doGetRequest 使用 token 认证发送 GET 请求并返回响应内容, 返回码非 200 时返回 ResponseCodeNot200 错误.
+// 返回 401 且 token 可刷新时, 刷新 token 后重试一次
+func doGetRequest(address string, params url.Values, token *Token) ([]byte, *ApiError) {
+	value := token.Get()
+	body, statusCode, apiErr := doGetRequestOnce(address, params, value)
+	if statusCode != http.StatusUnauthorized || !token.Refreshable() {
+		return body, apiErr
+	}
+	level.Info(logging.Logger).Log("msg", "UpYun returned 401, refreshing token", "endpoint", address, "domain", requestDomain(params))
+	if err := token.Refresh(value); err != nil {
+		level.Warn(logging.Logger).Log("msg", "Failed to refresh token", "endpoint", address, "err", err)
+		return body, apiErr
+	}
+	body, _, apiErr = doGetRequestOnce(address, params, token.Get())
+	return body, apiErr
+}
+
+func doGetRequestOnce(address string, params url.Values, token string) ([]byte, int, *ApiError) {
+	req, err := http.NewRequestWithContext(requestContext, "GET", address, nil)
+	if err != nil {
+		return nil, 0, NewRequestError(fmt.Sprintf("failed to create request, address: %s, error: %v", address, err), RequestFailed)
+	}
+	req.URL.RawQuery = params.Encode()
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	start := time.Now()
+	response, err := client.Do(req)
+	if err != nil {
+		level.Warn(logging.Logger).Log("msg", "UpYun request failed", "endpoint", address, "domain", requestDomain(params),
+			"duration_seconds", time.Since(start).Seconds(), "err", err)
+		return nil, 0, NewRequestError(fmt.Sprintf("request failed, address: %s, error: %v", address, err), RequestFailed)
+	}
+	defer response.Body.Close()
+	level.Debug(logging.Logger).Log("msg", "UpYun request finished", "endpoint", address, "domain", requestDomain(params),
+		"status", response.StatusCode, "duration_seconds", time.Since(start).Seconds())
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, response.StatusCode, NewRequestError(fmt.Sprintf("failed to read response body, address: %s, error: %v", address, err), RequestFailed)
+	}
+	if response.StatusCode != 200 {
+		apiErr := NewRequestError(fmt.Sprintf("return code not 200, address: %s, response code: %v, response body: %s",
+			address, response.StatusCode, string(body)), ResponseCodeNot200)
+		apiErr.StatusCode = response.StatusCode
+		return body, response.StatusCode, apiErr
+	}
+	return body, response.StatusCode, nil
+}
+
+// ValidateToken 用最近 5 分钟的带宽数据请求验证 token 是否可用于统计接口
+func ValidateToken(domain string, token *Token) *ApiError {
+	params := LastWindow(time.Now(), 5*time.Minute).params()
+	params.Add("flow_type", "cdn")
+	params.Add("domain", domain)
+	_, err := doGetRequest(httpBandWidthAddress, params, token)
+	return err
+}
@@ -3,12 +3,11 @@ package httpRequest
 import (
 	"encoding/json"
 	"fmt"
-	"io"
-	"log"
-	"net/http"
+	"github.com/go-kit/log/level"
 	"net/url"
 	"strings"
 	"time"
+	"upyun-exporter/logging"
 )
 
 const (
@@ -17,6 +16,14 @@ const (
 	httpBandWidthDetailAddress              = "https://api.upyun.com/flow/common_data"
 	ParseError                 ApiErrorType = iota
 	ResponseCodeNot200
+	RequestFailed
+)
+
+const (
+	bucketInfoAddress      = "https://api.upyun.com/buckets/info"
+	bucketUsageAddress     = "https://api.upyun.com/buckets/usage"
+	httpsManagerAddress    = "https://api.upyun.com/https/services/manager"
+	certificateInfoAddress = "https://api.upyun.com/https/certificate/info"
 )
 
 type DomainList struct {
@@ -48,6 +55,8 @@ type ApiErrorType uint8
 type ApiError struct {
 	Message string
 	T       ApiErrorType
+	// 接口返回的 http 状态码, 请求未发出时为 0
+	StatusCode int
 }
 
 func (r *ApiError) Error() string {
@@ -83,6 +92,40 @@ type FlowDetail struct {
 	Bytes     int     `json:"bytes"`
 }
 
+// BucketUsage 存储空间用量, files 字段部分空间类型不返回
+type BucketUsage struct {
+	Storage           float64  `json:"storage"`
+	Files             *float64 `json:"files,omitempty"`
+	InfrequentStorage float64  `json:"infrequent_storage"`
+}
+
+type HttpsManager struct {
+	Data struct {
+		Status  int `json:"status"`
+		Domains []struct {
+			Name          string `json:"name"`
+			Https         bool   `json:"https"`
+			ForceHttps    bool   `json:"force_https"`
+			CertificateId string `json:"certificate_id"`
+		} `json:"domains"`
+	} `json:"data"`
+}
+
+type CertificateInfo struct {
+	Data struct {
+		Status int `json:"status"`
+		Info   struct {
+			CertificateId string `json:"certificate_id"`
+			CommonName    string `json:"commonName"`
+			// 毫秒时间戳
+			Validity struct {
+				Start int64 `json:"start"`
+				End   int64 `json:"end"`
+			} `json:"validity"`
+		} `json:"info"`
+	} `json:"data"`
+}
+
 type BucketInfo struct {
 	BucketName         string        `json:"bucket_name,omitempty"`
 	Type               string        `json:"type,omitempty"`
@@ -109,143 +152,190 @@ type BucketInfo struct {
 	InfrequentAccess bool     `json:"infrequent_access,omitempty"`
 }
 
-func DoDomainListRequest(token string) []string {
-	req, err := http.NewRequest("GET", domainListAddress, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
+// BucketDomains 可见空间及其下的 cdn 域名
+type BucketDomains struct {
+	BucketName string   `json:"bucket"`
+	Domains    []string `json:"domains"`
+}
+
+// DoBucketDomainListRequest 返回可见空间及其下的 cdn 域名, 不包括 upaiyun/upcdn 默认域名
+func DoBucketDomainListRequest(token *Token) ([]BucketDomains, *ApiError) {
 	params := make(url.Values)
 	params.Add("business_type", "file")
 	params.Add("type", "ucdn")
-	req.URL.RawQuery = params.Encode()
-
-	req.Header.Set("Authorization", "Bearer "+token)
-
-	client := &http.Client{}
-	response, err := client.Do(req)
-	defer response.Body.Close()
-	if err != nil {
-		log.Fatal("请求失败", err)
-	}
-	body, err := io.ReadAll(response.Body)
-	if err != nil {
-		log.Fatalf("failed to read response body: %s", err)
-	}
-	if response.StatusCode != 200 {
-		log.Printf("request: %v, response: %v", req, response)
-		log.Fatalf("get domain list failed, return code not 200, response code: %v, response body: %s", response.StatusCode, string(body))
+	body, apiErr := doGetRequest(domainListAddress, params, token)
+	if apiErr != nil {
+		return nil, apiErr
 	}
 
 	var (
-		bucketList BucketList
-		domainList []string
+		bucketList    BucketList
+		bucketDomains []BucketDomains
 	)
 
-	err = json.Unmarshal(body, &bucketList)
+	err := json.Unmarshal(body, &bucketList)
 	if err != nil {
-		log.Fatal(err)
+		return nil, NewRequestError(fmt.Sprintf("Failed to decode body to domain list, response: %s, error: %v",
+			string(body), err), ParseError)
 	}
 
 	for _, bucket := range bucketList.Buckets {
-		bucketInfo := GetBucketInfo(bucket.BucketName, token)
+		bucketInfo, apiErr := GetBucketInfo(bucket.BucketName, token)
+		if apiErr != nil {
+			return nil, apiErr
+		}
 		if !bucketInfo.Visible {
 			continue
 		}
-		for _, domain := range bucket.Domains {
-			if strings.Contains(domain.Domain, "upaiyun") || strings.Contains(domain.Domain, "upcdn") {
-				continue
-			}
-			domainList = append(domainList, domain.Domain)
+		bucketDomains = append(bucketDomains, BucketDomains{BucketName: bucket.BucketName, Domains: cdnDomains(bucket.Domains)})
+	}
+	return bucketDomains, nil
+}
+
+// cdnDomains 返回空间绑定的域名中的 cdn 域名, 不包括 upaiyun/upcdn 默认域名
+func cdnDomains(domains []DomainList) []string {
+	var names []string
+	for _, domain := range domains {
+		if strings.Contains(domain.Domain, "upaiyun") || strings.Contains(domain.Domain, "upcdn") {
+			continue
 		}
+		names = append(names, domain.Domain)
 	}
-	return domainList
+	return names
 }
 
-func GetBucketInfo(domain string, token string) BucketInfo {
-	req, err := http.NewRequest("GET", "https://api.upyun.com/buckets/info", nil)
+func GetBucketInfo(bucketName string, token *Token) (BucketInfo, *ApiError) {
+	var bucketInfo BucketInfo
+	params := make(url.Values)
+	params.Add("bucket_name", bucketName)
+	body, apiErr := doGetRequest(bucketInfoAddress, params, token)
+	if apiErr != nil {
+		return bucketInfo, apiErr
+	}
+
+	err := json.Unmarshal(body, &bucketInfo)
 	if err != nil {
-		log.Fatal(err)
+		return bucketInfo, NewRequestError(fmt.Sprintf("Failed to decode body to bucket info, bucket: %s, response: %s, error: %v",
+			bucketName, string(body), err), ParseError)
 	}
-	params := make(url.Values)
-	params.Add("bucket_name", domain)
-	req.URL.RawQuery = params.Encode()
+	return bucketInfo, nil
+}
 
-	req.Header.Set("Authorization", "Bearer "+token)
+func DoBucketUsageRequest(bucketName string, token *Token) (BucketUsage, *ApiError) {
+	var usage BucketUsage
+	params := make(url.Values)
+	params.Add("bucket_name", bucketName)
+	body, apiErr := doGetRequest(bucketUsageAddress, params, token)
+	if apiErr != nil {
+		return usage, apiErr
+	}
 
-	client := &http.Client{}
-	response, err := client.Do(req)
-	defer response.Body.Close()
+	err := json.Unmarshal(body, &usage)
 	if err != nil {
-		log.Fatal("请求失败", err)
+		return usage, NewRequestError(fmt.Sprintf("Failed to decode body to bucket usage, bucket: %s, response: %s, error: %v",
+			bucketName, string(body), err), ParseError)
 	}
-	body, err := io.ReadAll(response.Body)
-	if err != nil {
-		log.Fatalf("failed to read response body: %s", err)
+	return usage, nil
+}
+
+func DoHttpsManagerRequest(domain string, token *Token) (HttpsManager, *ApiError) {
+	var manager HttpsManager
+	params := make(url.Values)
+	params.Add("domain", domain)
+	body, apiErr := doGetRequest(httpsManagerAddress, params, token)
+	if apiErr != nil {
+		return manager, apiErr
 	}
-	if response.StatusCode != 200 {
-		log.Printf("request: %v, response: %v", req, response)
-		log.Fatalf("get domain info failed, return code not 200, response code: %v, response body: %s", response.StatusCode, string(body))
+
+	err := json.Unmarshal(body, &manager)
+	if err != nil {
+		return manager, NewRequestError(fmt.Sprintf("Failed to decode body to https config, domain: %s, response: %s, error: %v",
+			domain, string(body), err), ParseError)
 	}
+	return manager, nil
+}
 
-	var bucketInfo BucketInfo
+func DoCertificateInfoRequest(certificateId string, token *Token) (CertificateInfo, *ApiError) {
+	var certificate CertificateInfo
+	params := make(url.Values)
+	params.Add("certificate_id", certificateId)
+	body, apiErr := doGetRequest(certificateInfoAddress, params, token)
+	if apiErr != nil {
+		return certificate, apiErr
+	}
 
-	err = json.Unmarshal(body, &bucketInfo)
+	err := json.Unmarshal(body, &certificate)
 	if err != nil {
-		log.Fatal(err)
+		return certificate, NewRequestError(fmt.Sprintf("Failed to decode body to certificate info, certificate: %s, response: %s, error: %v",
+			certificateId, string(body), err), ParseError)
 	}
-	return bucketInfo
+	return certificate, nil
 }
 
-func DoHttpBandWidthRequest(domain string, token string, rangeTime int64, delayTime int64) BandWidthList {
-	timeZone, _ := time.LoadLocation("Asia/Shanghai")
-	timeNow := time.Now().In(timeZone)
-	endTime := timeNow.Add(-time.Second * time.Duration(delayTime)).Format("2006-01-02 15:04:05")
-	startTime := timeNow.Add(-time.Second * time.Duration(rangeTime)).Format("2006-01-02 15:04:05")
-	req, err := http.NewRequest("GET", httpBandWidthAddress, nil)
-	if err != nil {
-		log.Fatal(err)
+// DoHttpBandWidthRequest 获取域名在 window 内的带宽数据, 只保留在 window.End 之前已结束的完整粒度
+func DoHttpBandWidthRequest(domain string, token *Token, window Window) (BandWidthList, *ApiError) {
+	BandWidth, apiErr := DoHttpBandWidthRangeRequest(domain, token, window.Start, window.End)
+	if apiErr != nil {
+		return BandWidth, apiErr
+	}
+	return BandWidth.CompleteBefore(window.End), nil
+}
+
+// IntervalDuration 返回数据的聚合粒度, 无法解析时返回 DefaultInterval
+func (b BandWidthList) IntervalDuration() time.Duration {
+	if interval, ok := ParseInterval(b.Interval); ok {
+		return interval
+	}
+	return DefaultInterval
+}
+
+// CompleteBefore 去掉在 end 时还未结束的粒度, 这些粒度的数据不完整会拉低平均值
+func (b BandWidthList) CompleteBefore(end time.Time) BandWidthList {
+	interval := b.IntervalDuration()
+	complete := b
+	complete.Data = b.Data[:0:0]
+	for _, point := range b.Data {
+		if time.Unix(int64(point.Time), 0).Add(interval).After(end) {
+			continue
+		}
+		complete.Data = append(complete.Data, point)
 	}
-	parm := make(url.Values)
-	parm.Add("start_time", startTime)
-	parm.Add("end_time", endTime)
+	return complete
+}
+
+// DoHttpBandWidthRangeRequest 获取域名在 startTime 至 endTime 之间的带宽数据
+func DoHttpBandWidthRangeRequest(domain string, token *Token, startTime time.Time, endTime time.Time) (BandWidthList, *ApiError) {
+	return DoHttpStatisticsRequest(domain, token, startTime, endTime, "backsource")
+}
+
+// DoHttpStatisticsRequest 获取域名在 startTime 至 endTime 之间的带宽, 流量及请求数统计, flowSource 为 cdn 或 backsource
+func DoHttpStatisticsRequest(domain string, token *Token, startTime time.Time, endTime time.Time, flowSource string) (BandWidthList, *ApiError) {
+	parm := Window{Start: startTime, End: endTime}.params()
 	parm.Add("flow_type", "cdn")
-	parm.Add("flow_source", "backsource")
+	parm.Add("flow_source", flowSource)
 	parm.Add("domain", domain)
-	req.URL.RawQuery = parm.Encode()
-	req.Header.Set("Authorization", "Bearer "+token)
-	client := &http.Client{}
-	response, err := client.Do(req)
-	defer response.Body.Close()
-	if err != nil {
-		log.Fatal("请求失败", err)
-	}
 	var BandWidth BandWidthList
-	body, err := io.ReadAll(response.Body)
-	if err != nil {
-		log.Fatal(err)
+	body, apiErr := doGetRequest(httpBandWidthAddress, parm, token)
+	if apiErr != nil {
+		return BandWidth, apiErr
 	}
-	if response.StatusCode != 200 {
-		log.Fatalf("Failed to get bandwidth data, response: %s", string(body))
-	}
-	err = json.Unmarshal(body, &BandWidth)
+	err := json.Unmarshal(body, &BandWidth)
 	if err != nil {
-		log.Fatal(err)
+		return BandWidth, NewRequestError(fmt.Sprintf("Failed to decode body to bandwidth data, domain: %s, response: %s, error: %v",
+			domain, string(body), err), ParseError)
 	}
-	return BandWidth
+	return BandWidth, nil
 }
 
-func DoHttpFlowDetailRequest(domain string, token string, rangeTime int64, delayTime int64, flowSource string) ([]FlowDetail, *ApiError) {
-	timeZone, _ := time.LoadLocation("Asia/Shanghai")
-	timeNow := time.Now().In(timeZone)
-	endTime := timeNow.Add(-time.Second * time.Duration(delayTime)).Format("2006-01-02 15:04:05")
-	startTime := timeNow.Add(-time.Second * time.Duration(rangeTime)).Format("2006-01-02 15:04:05")
-	req, err := http.NewRequest("GET", httpBandWidthDetailAddress, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	params := make(url.Values)
-	params.Add("start_time", startTime)
-	params.Add("end_time", endTime)
+// DoHttpFlowDetailRequest 获取域名在 window 内的流量明细, flowSource 为 cdn 或 backsource
+func DoHttpFlowDetailRequest(domain string, token *Token, window Window, flowSource string) ([]FlowDetail, *ApiError) {
+	return DoHttpFlowDetailRangeRequest(domain, token, window.Start, window.End, flowSource)
+}
+
+// DoHttpFlowDetailRangeRequest 获取域名在 startTime 至 endTime 之间的流量明细, flowSource 为 cdn 或 backsource,
+// 没有数据时返回空列表
+func DoHttpFlowDetailRangeRequest(domain string, token *Token, startTime time.Time, endTime time.Time, flowSource string) ([]FlowDetail, *ApiError) {
+	params := Window{Start: startTime, End: endTime}.params()
 	params.Add("query_type", "domain")
 	params.Add("query_value", domain)
 	params.Add("sum_data", "true")
@@ -257,29 +347,19 @@ func DoHttpFlowDetailRequest(domain string, token string, rangeTime int64, delay
 		params.Add("flow_source", flowSource)
 	}
 
-	req.URL.RawQuery = params.Encode()
-	req.Header.Set("Authorization", "Bearer "+token)
-
-	client := &http.Client{}
-	response, err := client.Do(req)
-	defer response.Body.Close()
-	if err != nil {
-		log.Fatal("请求失败", err)
-	}
-	body, err := io.ReadAll(response.Body)
-	if err != nil {
-		log.Fatalf("failed to read response body: %s", err)
+	body, apiErr := doGetRequest(httpBandWidthDetailAddress, params, token)
+	if apiErr != nil {
+		return nil, apiErr
 	}
-	if response.StatusCode != 200 {
-		log.Printf("request: %v", req)
-		return nil, NewRequestError(fmt.Sprintf("get flow data failed, return code not 200, response body: %s",
-			string(body)), ResponseCodeNot200)
+	// 窗口内没有数据时 response 返回为 {}
+	if strings.TrimSpace(string(body)) == "{}" {
+		return []FlowDetail{}, nil
 	}
 
 	var detailList []FlowDetail
-	err = json.Unmarshal(body, &detailList)
+	err := json.Unmarshal(body, &detailList)
 	if err != nil {
-		log.Printf("failed to collect domain flow detail, domain: %s, response: %s", domain, string(body))
+		level.Warn(logging.Logger).Log("msg", "Failed to decode flow detail", "domain", domain, "flow_source", flowSource, "response", string(body))
 		return nil, NewRequestError(fmt.Sprintf("Failed to decode body to flow detail, domain: %s, response: %s, error: %v",
 			domain, string(body), err), ParseError)
 
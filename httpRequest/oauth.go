@@ -0,0 +1,88 @@
+package httpRequest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const oauthTokenAddress = "https://api.upyun.com/oauth/tokens"
+
+// OAuthCredentials 用于自动获取 API token 的操作员账号密码, 或 client credentials
+type OAuthCredentials struct {
+	Username     string
+	Password     string
+	ClientId     string
+	ClientSecret string
+	// token 名称, 在 UpYun 控制台中区分不同来源的 token
+	Name     string
+	Lifetime time.Duration
+}
+
+type OAuthToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiredAt   int64  `json:"expired_at"`
+}
+
+// DoOAuthTokenRequest 使用账号密码或 client credentials 申请新的 API token
+func DoOAuthTokenRequest(credentials OAuthCredentials) (OAuthToken, *ApiError) {
+	var token OAuthToken
+	form := make(url.Values)
+	if credentials.ClientId != "" {
+		form.Add("grant_type", "client_credentials")
+		form.Add("client_id", credentials.ClientId)
+		form.Add("client_secret", credentials.ClientSecret)
+	} else {
+		form.Add("username", credentials.Username)
+		form.Add("password", credentials.Password)
+		form.Add("code", "")
+	}
+	form.Add("name", credentials.Name)
+	form.Add("scope", "global")
+	form.Add("expired_at", strconv.FormatInt(time.Now().Add(credentials.Lifetime).Unix(), 10))
+
+	req, err := http.NewRequestWithContext(requestContext, "POST", oauthTokenAddress, strings.NewReader(form.Encode()))
+	if err != nil {
+		return token, NewRequestError(fmt.Sprintf("failed to create oauth request, error: %v", err), RequestFailed)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := client.Do(req)
+	if err != nil {
+		return token, NewRequestError(fmt.Sprintf("oauth request failed, error: %v", err), RequestFailed)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return token, NewRequestError(fmt.Sprintf("failed to read oauth response body, error: %v", err), RequestFailed)
+	}
+	if response.StatusCode != 200 && response.StatusCode != 201 {
+		return token, NewRequestError(fmt.Sprintf("get oauth token failed, response code: %v, response body: %s",
+			response.StatusCode, string(body)), ResponseCodeNot200)
+	}
+
+	err = json.Unmarshal(body, &token)
+	if err != nil || token.AccessToken == "" {
+		return token, NewRequestError(fmt.Sprintf("Failed to decode body to oauth token, error: %v", err), ParseError)
+	}
+	return token, nil
+}
+
+// OAuthRefresher 返回通过 OAuth 申请 token 的 TokenRefresher
+func OAuthRefresher(credentials OAuthCredentials) TokenRefresher {
+	return func() (string, time.Time, error) {
+		token, err := DoOAuthTokenRequest(credentials)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		expiresAt := time.Unix(token.ExpiredAt, 0)
+		if token.ExpiredAt == 0 {
+			expiresAt = time.Now().Add(credentials.Lifetime)
+		}
+		return token.AccessToken, expiresAt, nil
+	}
+}
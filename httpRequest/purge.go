@@ -0,0 +1,69 @@
+package httpRequest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	purgeTaskAddress    = "https://api.upyun.com/v2/buckets/purge"
+	prefetchTaskAddress = "https://api.upyun.com/v2/buckets/prefetch"
+	purgeQuotaAddress   = "https://api.upyun.com/v2/buckets/purge/quota"
+)
+
+type PurgeTaskList struct {
+	Result []struct {
+		Url       string `json:"url"`
+		Status    string `json:"status"`
+		CreatedAt string `json:"created_at"`
+	} `json:"result"`
+}
+
+type PurgeQuotaItem struct {
+	Limit     float64 `json:"limit"`
+	Remaining float64 `json:"remaining"`
+}
+
+// PurgeQuota 每日刷新/预热配额
+type PurgeQuota struct {
+	Purge    PurgeQuotaItem `json:"purge"`
+	Prefetch PurgeQuotaItem `json:"prefetch"`
+}
+
+// DoPurgeTaskRequest 获取最近 rangeTime 秒内提交的刷新或预热任务, taskType 为 purge 或 prefetch
+func DoPurgeTaskRequest(token *Token, taskType string, rangeTime int64) (PurgeTaskList, *ApiError) {
+	var taskList PurgeTaskList
+	address := purgeTaskAddress
+	if taskType == "prefetch" {
+		address = prefetchTaskAddress
+	}
+	params := WindowAt(time.Now(), rangeTime, 0).params()
+	body, apiErr := doGetRequest(address, params, token)
+	if apiErr != nil {
+		return taskList, apiErr
+	}
+
+	err := json.Unmarshal(body, &taskList)
+	if err != nil {
+		return taskList, NewRequestError(fmt.Sprintf("Failed to decode body to %s task list, response: %s, error: %v",
+			taskType, string(body), err), ParseError)
+	}
+	return taskList, nil
+}
+
+func DoPurgeQuotaRequest(token *Token) (PurgeQuota, *ApiError) {
+	var quota PurgeQuota
+	body, apiErr := doGetRequest(purgeQuotaAddress, make(url.Values), token)
+	if apiErr != nil {
+		return quota, apiErr
+	}
+
+	err := json.Unmarshal(body, &quota)
+	if err != nil {
+		return quota, NewRequestError(fmt.Sprintf("Failed to decode body to purge quota, response: %s, error: %v",
+			string(body), err), ParseError)
+	}
+	return quota, nil
+}
@@ -0,0 +1,147 @@
+package httpRequest
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"upyun-exporter/logging"
+)
+
+// TokenRefresher 获取新的 token 及其过期时间
+type TokenRefresher func() (string, time.Time, error)
+
+// Token 可在运行时原子替换的 UpYun token, 每次请求时读取当前值
+type Token struct {
+	value     atomic.Value
+	mu        sync.Mutex
+	refresher TokenRefresher
+	expiresAt time.Time
+}
+
+func NewToken(value string) *Token {
+	t := &Token{}
+	t.value.Store(value)
+	return t
+}
+
+// NewRefreshingToken 立即通过 refresher 获取 token, 之后可在过期前或返回 401 时刷新
+func NewRefreshingToken(refresher TokenRefresher) (*Token, error) {
+	t := &Token{refresher: refresher}
+	t.value.Store("")
+	if err := t.Refresh(""); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Token) Get() string {
+	return t.value.Load().(string)
+}
+
+func (t *Token) Set(value string) {
+	t.value.Store(value)
+}
+
+// Refreshable token 是否可以通过 refresher 自动刷新
+func (t *Token) Refreshable() bool {
+	return t.refresher != nil
+}
+
+// ExpiresAt 自动刷新的 token 的过期时间
+func (t *Token) ExpiresAt() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.expiresAt
+}
+
+// Refresh 刷新 token. stale 为调用方认为已失效的 token, 如果已被其他请求刷新过则直接返回,
+// 避免并发的 401 触发多次刷新
+func (t *Token) Refresh(stale string) error {
+	if t.refresher == nil {
+		return errors.New("token is not refreshable")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Get() != stale {
+		return nil
+	}
+	value, expiresAt, err := t.refresher()
+	if err != nil {
+		return err
+	}
+	logging.AddSecret(value)
+	t.expiresAt = expiresAt
+	t.Set(value)
+	return nil
+}
+
+// KeepTokenFresh 在 token 过期前 margin 时间刷新 token, 刷新失败时每隔 margin/10 (至少 10 秒) 重试, 直到 done 关闭
+func KeepTokenFresh(token *Token, margin time.Duration, done <-chan bool, onRefresh func(), onError func(error)) {
+	retry := margin / 10
+	if retry < 10*time.Second {
+		retry = 10 * time.Second
+	}
+	for {
+		wait := time.Until(token.ExpiresAt().Add(-margin))
+		if wait < 0 {
+			wait = 0
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := token.Refresh(token.Get()); err != nil {
+			onError(err)
+			select {
+			case <-done:
+				return
+			case <-time.After(retry):
+			}
+			continue
+		}
+		onRefresh()
+	}
+}
+
+// ReadTokenFile 读取 token 文件内容, 去掉首尾空白
+func ReadTokenFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(string(content))
+	if value == "" {
+		return "", errors.New("token file is empty: " + path)
+	}
+	return value, nil
+}
+
+// WatchTokenFile 每隔 interval 检查一次 token 文件, 内容变化时替换 token 并调用 onReload,
+// 读取失败时调用 onError 并保留原 token. 使用轮询而不是 inotify, 兼容 Kubernetes secret 的软链接替换
+func WatchTokenFile(token *Token, path string, interval time.Duration, done <-chan bool, onReload func(string), onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			value, err := ReadTokenFile(path)
+			if err != nil {
+				onError(err)
+				continue
+			}
+			if value == token.Get() {
+				continue
+			}
+			token.Set(value)
+			onReload(value)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package httpRequest
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+	// 精简镜像中没有 tzdata 时使用内置的时区数据
+	_ "time/tzdata"
+)
+
+// DefaultTimeZone UpYun 统计接口默认使用的时区
+const DefaultTimeZone = "Asia/Shanghai"
+
+// DefaultInterval UpYun 统计数据的默认聚合粒度, 接口未返回 interval 时使用
+const DefaultInterval = 5 * time.Minute
+
+const (
+	timeLayout = "2006-01-02 15:04:05"
+	dateLayout = "2006-01-02"
+)
+
+// apiTimeZone 请求参数中时间所用的时区, 只在启动时通过 SetTimeZone 修改
+var apiTimeZone, _ = time.LoadLocation(DefaultTimeZone)
+
+// SetTimeZone 设置请求参数中时间所用的时区, name 为 IANA 时区名, 如 Asia/Shanghai
+func SetTimeZone(name string) error {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return err
+	}
+	apiTimeZone = location
+	return nil
+}
+
+// TimeZone 返回请求参数中时间所用的时区
+func TimeZone() *time.Location {
+	return apiTimeZone
+}
+
+// FormatTime 按接口要求的格式及时区格式化时间
+func FormatTime(t time.Time) string {
+	return t.In(apiTimeZone).Format(timeLayout)
+}
+
+// FormatDate 按接口要求的格式及时区格式化日期
+func FormatDate(t time.Time) string {
+	return t.In(apiTimeZone).Format(dateLayout)
+}
+
+// Window 查询的时间窗口
+type Window struct {
+	Start time.Time
+	End   time.Time
+}
+
+// WindowAt 计算 now 时刻的查询窗口, 开始时间=now-rangeTime秒, 结束时间=now-delayTime秒
+func WindowAt(now time.Time, rangeTime int64, delayTime int64) Window {
+	return Window{
+		Start: now.Add(-time.Second * time.Duration(rangeTime)),
+		End:   now.Add(-time.Second * time.Duration(delayTime)),
+	}
+}
+
+// LastWindow 返回截止到 now 的长度为 length 的窗口
+func LastWindow(now time.Time, length time.Duration) Window {
+	return Window{Start: now.Add(-length), End: now}
+}
+
+// WindowSpec 查询窗口配置, 窗口为 [now-Lag-Length, now-Lag]
+type WindowSpec struct {
+	// 窗口长度
+	Length time.Duration
+	// 窗口结束时间距当前时间的延迟, UpYun 统计数据有几分钟的延迟
+	Lag time.Duration
+}
+
+// Validate 检查窗口长度为正且延迟不为负
+func (s WindowSpec) Validate() error {
+	if s.Length <= 0 {
+		return fmt.Errorf("window length must be positive, got %s", s.Length)
+	}
+	if s.Lag < 0 {
+		return fmt.Errorf("window lag must not be negative, got %s", s.Lag)
+	}
+	return nil
+}
+
+// At 返回 now 时刻的查询窗口
+func (s WindowSpec) At(now time.Time) Window {
+	end := now.Add(-s.Lag)
+	return Window{Start: end.Add(-s.Length), End: end}
+}
+
+// params 返回窗口对应的 start_time, end_time 参数
+func (w Window) params() url.Values {
+	params := make(url.Values)
+	params.Add("start_time", FormatTime(w.Start))
+	params.Add("end_time", FormatTime(w.End))
+	return params
+}
+
+// Align 将窗口的开始及结束时间按 interval 向前对齐到 UpYun 聚合粒度的边界(按接口时区计算),
+// 对齐后窗口至少包含一个完整的粒度
+func (w Window) Align(interval time.Duration) Window {
+	if interval <= 0 {
+		return w
+	}
+	aligned := Window{Start: truncateTime(w.Start, interval), End: truncateTime(w.End, interval)}
+	if !aligned.Start.Before(aligned.End) {
+		aligned.Start = aligned.End.Add(-interval)
+	}
+	return aligned
+}
+
+func truncateTime(t time.Time, interval time.Duration) time.Time {
+	_, offset := t.In(apiTimeZone).Zone()
+	shift := time.Duration(offset) * time.Second
+	return t.Add(shift).Truncate(interval).Add(-shift)
+}
+
+var intervalPattern = regexp.MustCompile(`^(\d+)\s*([a-z]*)$`)
+
+// ParseInterval 解析接口返回的聚合粒度, 如 5min, 1hour, 1day 或秒数, 无法解析时返回 false
+func ParseInterval(interval string) (time.Duration, bool) {
+	match := intervalPattern.FindStringSubmatch(interval)
+	if match == nil {
+		return 0, false
+	}
+	value, err := strconv.Atoi(match[1])
+	if err != nil || value <= 0 {
+		return 0, false
+	}
+	var unit time.Duration
+	switch match[2] {
+	case "", "s", "sec", "second", "seconds":
+		unit = time.Second
+	case "m", "min", "mins", "minute", "minutes":
+		unit = time.Minute
+	case "h", "hour", "hours":
+		unit = time.Hour
+	case "d", "day", "days":
+		unit = 24 * time.Hour
+	default:
+		return 0, false
+	}
+	return time.Duration(value) * unit, true
+}
@@ -0,0 +1,214 @@
+package httpRequest
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWindowSpecValidate(t *testing.T) {
+	tests := []struct {
+		spec    WindowSpec
+		wantErr bool
+	}{
+		{WindowSpec{Length: 25 * time.Minute, Lag: 5 * time.Minute}, false},
+		{WindowSpec{Length: time.Minute}, false},
+		{WindowSpec{Length: 0, Lag: 5 * time.Minute}, true},
+		{WindowSpec{Length: -time.Minute}, true},
+		{WindowSpec{Length: time.Minute, Lag: -time.Second}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.spec.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%+v.Validate() error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWindowSpecAt(t *testing.T) {
+	now := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		spec WindowSpec
+		want Window
+	}{
+		{
+			WindowSpec{Length: 25 * time.Minute, Lag: 5 * time.Minute},
+			Window{Start: time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC), End: time.Date(2024, 1, 2, 9, 55, 0, 0, time.UTC)},
+		},
+		{
+			WindowSpec{Length: time.Hour},
+			Window{Start: time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC), End: now},
+		},
+	}
+	for _, tt := range tests {
+		got := tt.spec.At(now)
+		if !got.Start.Equal(tt.want.Start) || !got.End.Equal(tt.want.End) {
+			t.Errorf("%+v.At() = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestWindowAlign(t *testing.T) {
+	defer func(location *time.Location) { apiTimeZone = location }(apiTimeZone)
+	utc := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 2, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name     string
+		timeZone string
+		window   Window
+		interval time.Duration
+		want     Window
+	}{
+		{
+			name:     "5min",
+			timeZone: "Asia/Shanghai",
+			window:   Window{Start: utc(9, 32), End: utc(9, 58)},
+			interval: 5 * time.Minute,
+			want:     Window{Start: utc(9, 30), End: utc(9, 55)},
+		},
+		{
+			name:     "already aligned",
+			timeZone: "Asia/Shanghai",
+			window:   Window{Start: utc(9, 30), End: utc(9, 55)},
+			interval: 5 * time.Minute,
+			want:     Window{Start: utc(9, 30), End: utc(9, 55)},
+		},
+		{
+			name:     "shorter than interval",
+			timeZone: "Asia/Shanghai",
+			window:   Window{Start: utc(9, 31), End: utc(9, 33)},
+			interval: 5 * time.Minute,
+			want:     Window{Start: utc(9, 25), End: utc(9, 30)},
+		},
+		{
+			// 按北京时间的零点对齐, 即 UTC 16:00
+			name:     "1day in Asia/Shanghai",
+			timeZone: "Asia/Shanghai",
+			window:   Window{Start: time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC), End: utc(3, 0)},
+			interval: 24 * time.Hour,
+			want:     Window{Start: time.Date(2023, 12, 31, 16, 0, 0, 0, time.UTC), End: time.Date(2024, 1, 1, 16, 0, 0, 0, time.UTC)},
+		},
+		{
+			// +05:30 时区按小时对齐时落在半点
+			name:     "1hour in Asia/Kolkata",
+			timeZone: "Asia/Kolkata",
+			window:   Window{Start: utc(8, 10), End: utc(10, 10)},
+			interval: time.Hour,
+			want:     Window{Start: utc(7, 30), End: utc(9, 30)},
+		},
+		{
+			name:     "zero interval",
+			timeZone: "Asia/Shanghai",
+			window:   Window{Start: utc(9, 32), End: utc(9, 58)},
+			interval: 0,
+			want:     Window{Start: utc(9, 32), End: utc(9, 58)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SetTimeZone(tt.timeZone); err != nil {
+				t.Fatal(err)
+			}
+			got := tt.window.Align(tt.interval)
+			if !got.Start.Equal(tt.want.Start) || !got.End.Equal(tt.want.End) {
+				t.Errorf("Align(%s) = [%v, %v], want [%v, %v]", tt.interval, got.Start, got.End, tt.want.Start, tt.want.End)
+			}
+		})
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	tests := []struct {
+		interval string
+		want     time.Duration
+		ok       bool
+	}{
+		{"5min", 5 * time.Minute, true},
+		{"1hour", time.Hour, true},
+		{"1day", 24 * time.Hour, true},
+		{"300", 5 * time.Minute, true},
+		{"60s", time.Minute, true},
+		{"10 minutes", 10 * time.Minute, true},
+		{"", 0, false},
+		{"0min", 0, false},
+		{"5weeks", 0, false},
+		{"min", 0, false},
+		{"-5min", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseInterval(tt.interval)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ParseInterval(%q) = %s, %v, want %s, %v", tt.interval, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestBandWidthListCompleteBefore(t *testing.T) {
+	// 09:30, 09:35, 09:40 UTC 三个粒度
+	const body = `{"interval": "%s", "data": [{"time": 1704187800, "reqs": 1}, {"time": 1704188100, "reqs": 2}, {"time": 1704188400, "reqs": 3}]}`
+	tests := []struct {
+		name     string
+		interval string
+		end      time.Time
+		want     []float64
+	}{
+		{"last point incomplete", "5min", time.Date(2024, 1, 2, 9, 43, 0, 0, time.UTC), []float64{1, 2}},
+		{"end on boundary", "5min", time.Date(2024, 1, 2, 9, 45, 0, 0, time.UTC), []float64{1, 2, 3}},
+		{"default interval", "", time.Date(2024, 1, 2, 9, 40, 0, 0, time.UTC), []float64{1, 2}},
+		{"hourly interval", "1hour", time.Date(2024, 1, 2, 10, 30, 0, 0, time.UTC), []float64{1}},
+		{"nothing complete", "5min", time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var list BandWidthList
+			if err := json.Unmarshal([]byte(fmt.Sprintf(body, tt.interval)), &list); err != nil {
+				t.Fatal(err)
+			}
+			complete := list.CompleteBefore(tt.end)
+			var got []float64
+			for _, point := range complete.Data {
+				got = append(got, point.Reqs)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("CompleteBefore() reqs = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("CompleteBefore() reqs = %v, want %v", got, tt.want)
+				}
+			}
+			if len(list.Data) != 3 {
+				t.Errorf("CompleteBefore() modified the original list")
+			}
+		})
+	}
+}
+
+func TestFormatTime(t *testing.T) {
+	defer func(location *time.Location) { apiTimeZone = location }(apiTimeZone)
+	instant := time.Date(2024, 1, 1, 20, 30, 0, 0, time.UTC)
+	tests := []struct {
+		timeZone string
+		wantTime string
+		wantDate string
+	}{
+		{"Asia/Shanghai", "2024-01-02 04:30:00", "2024-01-02"},
+		{"UTC", "2024-01-01 20:30:00", "2024-01-01"},
+		{"America/New_York", "2024-01-01 15:30:00", "2024-01-01"},
+	}
+	for _, tt := range tests {
+		if err := SetTimeZone(tt.timeZone); err != nil {
+			t.Fatal(err)
+		}
+		if got := FormatTime(instant); got != tt.wantTime {
+			t.Errorf("FormatTime() in %s = %q, want %q", tt.timeZone, got, tt.wantTime)
+		}
+		if got := FormatDate(instant); got != tt.wantDate {
+			t.Errorf("FormatDate() in %s = %q, want %q", tt.timeZone, got, tt.wantDate)
+		}
+	}
+	if err := SetTimeZone("Not/AZone"); err == nil {
+		t.Errorf("SetTimeZone() accepted an invalid time zone")
+	}
+}
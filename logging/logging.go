@@ -0,0 +1,77 @@
+package logging
+
+import (
+	"fmt"
+	"github.com/go-kit/log"
+	"github.com/prometheus/common/promlog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Logger 全局日志, Init 之前输出 logfmt 格式的全部级别日志
+var Logger log.Logger = log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+
+var (
+	bearerRegexp = regexp.MustCompile(`(?i)(bearer\s+)[^\s"',]+`)
+	secretsMu    sync.RWMutex
+	secrets      []string
+)
+
+const redacted = "<redacted>"
+
+// Init 按 --log.level 和 --log.format 初始化全局日志, 输出的所有值都会经过 token 脱敏
+func Init(config *promlog.Config) {
+	var base log.Logger
+	if config.Format != nil && config.Format.String() == "json" {
+		base = log.NewJSONLogger(log.NewSyncWriter(os.Stderr))
+	} else {
+		base = log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+	}
+	Logger = promlog.NewWithLogger(&redactLogger{next: base}, config)
+}
+
+// AddSecret 注册需要在日志中脱敏的字符串, 例如 UpYun token
+func AddSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, s := range secrets {
+		if s == secret {
+			return
+		}
+	}
+	secrets = append(secrets, secret)
+}
+
+// Redact 替换字符串中的 Authorization bearer token 及已注册的 secret
+func Redact(value string) string {
+	value = bearerRegexp.ReplaceAllString(value, "${1}"+redacted)
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for _, secret := range secrets {
+		value = strings.ReplaceAll(value, secret, redacted)
+	}
+	return value
+}
+
+type redactLogger struct {
+	next log.Logger
+}
+
+func (l *redactLogger) Log(keyvals ...interface{}) error {
+	for i, value := range keyvals {
+		switch v := value.(type) {
+		case string:
+			keyvals[i] = Redact(v)
+		case error:
+			keyvals[i] = Redact(v.Error())
+		case fmt.Stringer:
+			keyvals[i] = Redact(v.String())
+		}
+	}
+	return l.next.Log(keyvals...)
+}
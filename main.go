@@ -1,65 +1,504 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"log"
+	"github.com/prometheus/common/version"
+	"github.com/prometheus/exporter-toolkit/web"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 	"upyun-exporter/exporter"
 	"upyun-exporter/httpRequest"
+	"upyun-exporter/logging"
 )
 
-var domainList []string
+// discovery 一次域名发现的结果, 发布后不再修改, 刷新时整体替换
+type discovery struct {
+	domains []string
+	buckets []string
+	// 域名所属空间
+	domainBuckets map[string]string
+}
+
+var (
+	// 最近一次域名发现的结果, 由后台刷新任务替换, 抓取及 http handler 并发读取
+	discovered atomic.Pointer[discovery]
+	// 域名列表至少成功获取过一次, 且 token 通过校验后才算 ready
+	tokenValidated int32
+)
+
+// FetchDomainList 刷新域名发现的结果, 失败时记录日志并保留上一次的结果
+func FetchDomainList(token *httpRequest.Token) error {
+	list, apiErr := httpRequest.DoBucketDomainListRequest(token)
+	if apiErr != nil {
+		if !httpRequest.Canceled() {
+			level.Warn(logging.Logger).Log("msg", "Failed to get domain list", "err", apiErr)
+		}
+		return apiErr
+	}
+	var (
+		domains []string
+		buckets []string
+	)
+	bucketDomains := make(map[string]string)
+	for _, bucket := range list {
+		buckets = append(buckets, bucket.BucketName)
+		for _, domain := range bucket.Domains {
+			domains = append(domains, domain)
+			bucketDomains[domain] = bucket.BucketName
+		}
+	}
+	discovered.Store(&discovery{domains: domains, buckets: buckets, domainBuckets: bucketDomains})
+	return nil
+}
+
+// DomainList 返回最近一次发现的 cdn 域名列表
+func DomainList() []string {
+	if d := discovered.Load(); d != nil {
+		return d.domains
+	}
+	return nil
+}
+
+// BucketList 返回最近一次发现的空间名列表
+func BucketList() []string {
+	if d := discovered.Load(); d != nil {
+		return d.buckets
+	}
+	return nil
+}
+
+// DomainBucket 返回域名所属的空间名
+func DomainBucket(domain string) string {
+	if d := discovered.Load(); d != nil {
+		return d.domainBuckets[domain]
+	}
+	return ""
+}
+
+// token 校验失败后的重试间隔, 每次失败翻倍直到上限
+const (
+	validateMinBackoff = 5 * time.Second
+	validateMaxBackoff = 5 * time.Minute
+)
+
+// ValidateToken 校验 token, 返回 token 是否已通过校验
+func ValidateToken(token *httpRequest.Token) bool {
+	if atomic.LoadInt32(&tokenValidated) == 1 {
+		return true
+	}
+	domains := DomainList()
+	if len(domains) == 0 {
+		return false
+	}
+	if err := httpRequest.ValidateToken(domains[0], token); err != nil {
+		level.Warn(logging.Logger).Log("msg", "Failed to validate token", "err", err)
+		return false
+	}
+	atomic.StoreInt32(&tokenValidated, 1)
+	return true
+}
+
+// validateTokenWithBackoff 重试校验 token 直到成功或退出, 避免 /-/ready 等到下次刷新域名列表才恢复
+func validateTokenWithBackoff(token *httpRequest.Token, done chan bool) {
+	backoff := validateMinBackoff
+	for !ValidateToken(token) {
+		select {
+		case <-done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > validateMaxBackoff {
+			backoff = validateMaxBackoff
+		}
+	}
+}
 
-func FetchDomainList(token string) {
-	domainList = httpRequest.DoDomainListRequest(token)
+func FetchBucketUsage(token *httpRequest.Token, storage *exporter.StorageExporter) {
+	usage := make(map[string]httpRequest.BucketUsage)
+	var errs []error
+	for _, bucket := range BucketList() {
+		bucketUsage, err := httpRequest.DoBucketUsageRequest(bucket, token)
+		if err != nil {
+			level.Warn(logging.Logger).Log("msg", "Failed to get bucket usage", "bucket", bucket, "err", err)
+			errs = append(errs, err)
+			continue
+		}
+		usage[bucket] = bucketUsage
+	}
+	storage.SetUsage(usage, errors.Join(errs...))
+}
+
+// FetchCertificates 刷新所有域名的 https 状态及证书信息, 查询失败的域名保留上次的结果,
+// 避免证书过期时间的指标因临时错误消失
+func FetchCertificates(token *httpRequest.Token, certificateExporter *exporter.CertificateExporter) {
+	certificates := make(map[string]exporter.DomainCertificate)
+	certificateInfos := make(map[string]httpRequest.CertificateInfo)
+	var errs []error
+	for _, domain := range DomainList() {
+		previous, hasPrevious := certificateExporter.Certificate(domain)
+		manager, err := httpRequest.DoHttpsManagerRequest(domain, token)
+		if err != nil {
+			level.Warn(logging.Logger).Log("msg", "Failed to get https config", "domain", domain, "err", err)
+			errs = append(errs, err)
+			if hasPrevious {
+				certificates[domain] = previous
+			}
+			continue
+		}
+		for _, item := range manager.Data.Domains {
+			if item.Name != domain {
+				continue
+			}
+			certificate := exporter.DomainCertificate{
+				HttpsEnabled:  item.Https,
+				CertificateId: item.CertificateId,
+			}
+			// 先记录 https 状态, 证书信息查询失败时沿用上次的证书信息
+			certificates[domain] = certificate
+			if item.CertificateId == "" {
+				continue
+			}
+			info, ok := certificateInfos[item.CertificateId]
+			if !ok {
+				info, err = httpRequest.DoCertificateInfoRequest(item.CertificateId, token)
+				if err != nil {
+					level.Warn(logging.Logger).Log("msg", "Failed to get certificate info", "domain", domain, "err", err)
+					errs = append(errs, err)
+					if hasPrevious && previous.CertificateId != "" {
+						certificate.CertificateId = previous.CertificateId
+						certificate.CommonName = previous.CommonName
+						certificate.NotAfter = previous.NotAfter
+					} else {
+						certificate.CertificateId = ""
+					}
+					certificates[domain] = certificate
+					continue
+				}
+				certificateInfos[item.CertificateId] = info
+			}
+			certificate.CommonName = info.Data.Info.CommonName
+			certificate.NotAfter = float64(info.Data.Info.Validity.End) / 1000
+			certificates[domain] = certificate
+		}
+	}
+	certificateExporter.SetCertificates(certificates, errors.Join(errs...))
 }
 
 func main() {
-	bucketToken := flag.String("bucket_token", os.Getenv("UpYun_Bucket_Token"), "upYun bucket token")
-	token := flag.String("token", os.Getenv("UpYun_Token"), "upYun token")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check":
+			runCheck(os.Args[2:])
+			return
+		case "domains":
+			runDomains(os.Args[2:])
+			return
+		case "stats":
+			runStats(os.Args[2:])
+			return
+		case "flow":
+			runFlow(os.Args[2:])
+			return
+		case "export":
+			runExport(os.Args[2:])
+			return
+		}
+	}
+
+	credentials := registerCredentialFlags(flag.CommandLine)
 	host := flag.String("host", "0.0.0.0", "服务监听地址")
 	port := flag.Int("port", 9300, "服务监听端口")
-	delayTime := flag.Int64("delayTime", 300, "时间偏移量, 结束时间=now-delay_seconds")
-	rangeTime := flag.Int64("rangeTime", 1800, "选取时间范围, 开始时间=now-range_seconds, 结束时间=now")
+	window := registerWindowFlags(flag.CommandLine)
+	collectors := registerCollectorFlags(flag.CommandLine)
+	configFile := flag.String("config.file", "", "cdn采集配置文件(YAML), 可按域名或空间名设置窗口, 刷新间隔, 采集的指标及附加label")
 	tickerTime := flag.Int("tickerTime", 3600, "刷新域名列表间隔时间")
+	storageTickerTime := flag.Int("storageTickerTime", 21600, "刷新空间存储用量间隔时间")
 	metricsPath := flag.String("metricsPath", "/metrics", "默认的metrics路径")
+	webConfigFile := flag.String("web.config.file", "", "开启TLS或basic auth的web配置文件路径, 格式同Prometheus exporter-toolkit, 每次连接时重新加载")
+	topN := flag.Int("topN", 10, "top N统计的条数")
+	topLabelLength := flag.Int("topLabelLength", 128, "top N统计label值的最大长度, 超出部分截断")
+	accessLogDir := flag.String("accessLogDir", "", "从本地目录<accessLogDir>/<domain>/读取访问日志, 而不是从UpYun下载")
+	accessLogTickerTime := flag.Int("accessLogTickerTime", 3600, "检查新访问日志间隔时间")
+	accessLogPathDepth := flag.Int("accessLogPathDepth", 2, "访问日志path label保留的目录层级, 文件名折叠为*, 0为不截断")
+	logConfig := registerLogFlags(flag.CommandLine)
+	registerTimeZoneFlag(flag.CommandLine)
+	once := flag.Bool("once", false, "只执行一次域名发现和cdn指标采集, 以文本格式输出后退出, 有域名采集失败时以非0状态退出")
+	onceOutput := flag.String("once.output", "", "once模式的输出文件, 默认输出到stdout")
+	pushURL := flag.String("push.url", "", "Pushgateway地址, 设置后只执行一次域名发现和cdn指标采集, 推送后退出")
+	pushJob := flag.String("push.job", "upyun_exporter", "推送到Pushgateway使用的job名")
+	pushGrouping := keyValueFlag{}
+	flag.Var(pushGrouping, "push.grouping", "推送到Pushgateway使用的grouping label, 格式为key=value, 可重复")
+	otlpEndpoint := flag.String("otlp.endpoint", "", "OTLP collector地址, host:port或完整URL, 设置后定期以OTLP推送cdn指标")
+	otlpProtocol := flag.String("otlp.protocol", "grpc", "OTLP协议, 可选 grpc, http")
+	otlpInsecure := flag.Bool("otlp.insecure", false, "OTLP不使用TLS")
+	otlpInterval := flag.Duration("otlp.interval", time.Minute, "未设置sink.interval时定期采集cdn指标推送OTLP的间隔时间")
+	otlpAccount := flag.String("otlp.account", "", "OTLP resource属性upyun.account的值")
+	otlpHeaders := keyValueFlag{}
+	flag.Var(otlpHeaders, "otlp.header", "OTLP请求附带的header, 格式为key=value, 可重复")
+	influxURL := flag.String("sink.influxdb.url", "", "InfluxDB写入地址, 如 http://influxdb:8086/write?db=upyun, 设置后每次采集的cdn指标以line protocol写入")
+	influxToken := flag.String("sink.influxdb.token", "", "InfluxDB写入使用的token")
+	statsdAddress := flag.String("sink.statsd.address", "", "StatsD地址host:port, 设置后每次采集的cdn指标以gauge通过UDP发送")
+	graphiteAddress := flag.String("sink.graphite.address", "", "Graphite地址host:port, 设置后每次采集的cdn指标以plaintext协议通过TCP发送")
+	sinkPrefix := flag.String("sink.prefix", "upyun", "StatsD/Graphite指标名前缀")
+	sinkInterval := flag.Duration("sink.interval", 0, "定期采集cdn指标写入sink的间隔时间, 设置后Prometheus抓取返回最近一次定期采集的结果, 0为在每次Prometheus抓取时写入")
+	shutdownTimeout := flag.Duration("shutdownTimeout", 30*time.Second, "退出时等待进行中的抓取完成的最长时间, 超时后取消进行中的UpYun请求")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s [subcommand]:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintln(flag.CommandLine.Output(), "\n"+subcommandUsage)
+	}
 	flag.Parse()
+	logging.Init(logConfig)
+	windows, err := window.config()
+	if err != nil {
+		level.Error(logging.Logger).Log("msg", "Invalid query window", "err", err)
+		os.Exit(1)
+	}
+	settings := &exporter.Settings{Windows: windows, Collectors: collectors.names(), DomainBucket: DomainBucket}
+	if *configFile != "" {
+		settings.Rules, err = exporter.LoadDomainRules(*configFile)
+		if err != nil {
+			level.Error(logging.Logger).Log("msg", "Failed to load config file", "file", *configFile, "err", err)
+			os.Exit(1)
+		}
+	}
+	tokenSource, bucketTokenSource := credentials.load()
+	if *once {
+		if FetchDomainList(bucketTokenSource) != nil {
+			os.Exit(1)
+		}
+		os.Exit(runOnce(exporter.CdnCloudExporter(DomainList, tokenSource, settings), *onceOutput))
+	}
+	if *pushURL != "" {
+		if FetchDomainList(bucketTokenSource) != nil {
+			os.Exit(1)
+		}
+		os.Exit(runPush(exporter.CdnCloudExporter(DomainList, tokenSource, settings), *pushURL, *pushJob, pushGrouping))
+	}
 	ticker := time.NewTicker(time.Duration(*tickerTime) * time.Second)
+	defer ticker.Stop()
 	done := make(chan bool)
-	FetchDomainList(*bucketToken)
+	// 后台刷新任务, 退出时等待其结束
+	var background sync.WaitGroup
+	credentials.watch(tokenSource, bucketTokenSource, done, &background)
+	// storage, certificate 等不按域名查询窗口采集的 collector
+	collectorSet := exporter.NewCollectorSet()
+	var certificate *exporter.CertificateExporter
+	if collectors.isEnabled(exporter.CollectorCertificate) {
+		certificate = exporter.CdnCertificateExporter()
+		collectorSet.Add(exporter.CollectorCertificate, certificate)
+	}
+	// 首次发现失败时不退出, /-/ready 返回 503 直到下次刷新成功
+	_ = FetchDomainList(bucketTokenSource)
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		validateTokenWithBackoff(tokenSource, done)
+	}()
+	background.Add(1)
 	go func() {
+		defer background.Done()
+		if certificate != nil {
+			FetchCertificates(bucketTokenSource, certificate)
+		}
 		for {
 			select {
 			case <-done:
 				return
 			case <-ticker.C:
-				FetchDomainList(*bucketToken)
+				_ = FetchDomainList(bucketTokenSource)
+				if certificate != nil {
+					FetchCertificates(bucketTokenSource, certificate)
+				}
 			}
 		}
 	}()
+	if collectors.isEnabled(exporter.CollectorStorage) {
+		storage := exporter.BucketStorageExporter()
+		collectorSet.Add(exporter.CollectorStorage, storage)
+		storageTicker := time.NewTicker(time.Duration(*storageTickerTime) * time.Second)
+		defer storageTicker.Stop()
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			FetchBucketUsage(bucketTokenSource, storage)
+			for {
+				select {
+				case <-done:
+					return
+				case <-storageTicker.C:
+					FetchBucketUsage(bucketTokenSource, storage)
+				}
+			}
+		}()
+	}
 
-	cdn := exporter.CdnCloudExporter(&domainList, *token, *rangeTime, *delayTime)
-	prometheus.MustRegister(cdn)
+	cdn := exporter.CdnCloudExporter(DomainList, tokenSource, settings)
+	snapshots := exporter.NewSnapshotStore()
+	cdn.AddSink(snapshots)
+	if *influxURL != "" {
+		logging.AddSecret(*influxToken)
+		cdn.AddSink(exporter.NewInfluxSink(*influxURL, *influxToken))
+	}
+	if *statsdAddress != "" {
+		cdn.AddSink(exporter.NewStatsdSink(*statsdAddress, *sinkPrefix))
+	}
+	if *graphiteAddress != "" {
+		cdn.AddSink(exporter.NewGraphiteSink(*graphiteAddress, *sinkPrefix))
+	}
+	var otlpSink *exporter.OtlpSink
+	if *otlpEndpoint != "" {
+		var err error
+		otlpSink, err = exporter.NewOtlpSink(cdn, exporter.OtlpConfig{
+			Protocol: *otlpProtocol,
+			Endpoint: *otlpEndpoint,
+			Insecure: *otlpInsecure,
+			Headers:  otlpHeaders,
+			Account:  *otlpAccount,
+		}, DomainBucket)
+		if err != nil {
+			level.Error(logging.Logger).Log("msg", "Failed to create otlp exporter", "err", err)
+			os.Exit(1)
+		}
+		cdn.AddSink(otlpSink)
+	}
+	// OTLP 为推送模式, 未设置 sink.interval 时按 otlp.interval 定期采集
+	refreshInterval := *sinkInterval
+	if refreshInterval == 0 && otlpSink != nil {
+		refreshInterval = *otlpInterval
+	}
+	if refreshInterval > 0 {
+		// 定期采集时 sink 只由定时任务写入, Prometheus 抓取返回最近一次采集的结果
+		cdn.SetRefreshOnly(true)
+		sinkTicker := time.NewTicker(refreshInterval)
+		defer sinkTicker.Stop()
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			cdn.Refresh()
+			for {
+				select {
+				case <-done:
+					return
+				case <-sinkTicker.C:
+					cdn.Refresh()
+				}
+			}
+		}()
+	}
+	// cdn 与其他 collector 一起注册, 共用 upyun_scrape_collector_* 指标
+	collectorSet.AddGroup(cdn)
+	prometheus.MustRegister(version.NewCollector("upyun_exporter"))
+	prometheus.MustRegister(tokenReloadTimestamp, tokenReloadFailures)
+	if collectors.isEnabled(exporter.CollectorPurge) {
+		collectorSet.Add(exporter.CollectorPurge, exporter.CdnPurgeTaskExporter(tokenSource))
+	}
+	if collectors.isEnabled(exporter.CollectorTop) {
+		if *topN <= 0 || *topN > 100 {
+			level.Error(logging.Logger).Log("msg", "Invalid topN, must be between 1 and 100", "topN", *topN)
+			os.Exit(1)
+		}
+		collectorSet.Add(exporter.CollectorTop, exporter.CdnTopAnalysisExporter(DomainList, tokenSource, *topN, *topLabelLength))
+	}
+	if collectors.isEnabled(exporter.CollectorAccessLog) {
+		accessLog := exporter.CdnAccessLogExporter(DomainList, tokenSource, *accessLogDir, *accessLogPathDepth)
+		collectorSet.Add(exporter.CollectorAccessLog, accessLog)
+		accessLogTicker := time.NewTicker(time.Duration(*accessLogTickerTime) * time.Second)
+		defer accessLogTicker.Stop()
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			accessLog.Refresh()
+			for {
+				select {
+				case <-done:
+					return
+				case <-accessLogTicker.C:
+					accessLog.Refresh()
+				}
+			}
+		}()
+	}
+	prometheus.MustRegister(collectorSet)
 	listenAddress := net.JoinHostPort(*host, strconv.Itoa(*port))
-	log.Println(listenAddress)
-	log.Println("Running on", listenAddress)
+	level.Info(logging.Logger).Log("msg", "Starting upyun-exporter", "version", version.Info())
+	level.Info(logging.Logger).Log("msg", "Build context", "build_context", version.BuildContext())
+	level.Info(logging.Logger).Log("msg", "Listening", "address", listenAddress)
 	http.Handle(*metricsPath, promhttp.Handler()) //注册
-
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		_, _ = w.Write([]byte(`<html>
-           <head><title>UPYUN CDN Exporter</title></head>
-           <body>
-           <h1>Upyun cdn exporter</h1>
-           <p><a href='` + *metricsPath + `'>Metrics</a></p>
-           </body>
-           </html>`))
+	registerAPI(snapshots)
+	http.HandleFunc("/-/healthy", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("Healthy"))
 	})
+	http.HandleFunc("/-/ready", func(w http.ResponseWriter, r *http.Request) {
+		if discovered.Load() == nil || atomic.LoadInt32(&tokenValidated) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("Not ready"))
+			return
+		}
+		_, _ = w.Write([]byte("Ready"))
+	})
+
+	registerStatusPages(snapshots, *metricsPath)
 
-	log.Fatal(http.ListenAndServe(listenAddress, nil))
+	server := &http.Server{}
+	webSystemdSocket := false
+	webFlags := &web.FlagConfig{
+		WebListenAddresses: &[]string{listenAddress},
+		WebSystemdSocket:   &webSystemdSocket,
+		WebConfigFile:      webConfigFile,
+	}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- web.ListenAndServe(server, webFlags, logging.Logger)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	select {
+	case err := <-serverErr:
+		level.Error(logging.Logger).Log("msg", "Failed to run http server", "err", err)
+		os.Exit(1)
+	case sig := <-signals:
+		level.Info(logging.Logger).Log("msg", "Received signal, shutting down", "signal", sig)
+	}
+
+	close(done)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	// 超时后取消进行中的 UpYun 请求, 让未完成的抓取尽快返回
+	go func() {
+		<-shutdownCtx.Done()
+		httpRequest.CancelRequests()
+	}()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		level.Warn(logging.Logger).Log("msg", "Failed to shutdown http server gracefully", "err", err)
+	}
+	if err := <-serverErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		level.Error(logging.Logger).Log("msg", "Http server error", "err", err)
+	}
+	httpRequest.CancelRequests()
+	background.Wait()
+	cdn.Close()
+	if otlpSink != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := otlpSink.Shutdown(ctx); err != nil {
+			level.Warn(logging.Logger).Log("msg", "Failed to shutdown otlp exporter", "err", err)
+		}
+		cancel()
+	}
+	level.Info(logging.Logger).Log("msg", "upyun-exporter stopped")
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"io"
+	"os"
+	"upyun-exporter/exporter"
+	"upyun-exporter/logging"
+)
+
+// runOnce 执行一次 CdnExporter.Collect, 以文本格式输出到 output (为空时输出到 stdout),
+// 返回进程退出码, 有域名采集失败时返回 1
+func runOnce(cdn *exporter.CdnExporter, output string) int {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(cdn)
+	metricFamilies, gatherErr := registry.Gather()
+	if gatherErr != nil {
+		level.Error(logging.Logger).Log("msg", "Error gathering metrics", "err", gatherErr)
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			level.Error(logging.Logger).Log("msg", "Failed to create output file", "file", output, "err", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	encoder := expfmt.NewEncoder(w, expfmt.FmtText)
+	for _, metricFamily := range metricFamilies {
+		if err := encoder.Encode(metricFamily); err != nil {
+			level.Error(logging.Logger).Log("msg", "Failed to write metrics", "err", err)
+			return 1
+		}
+	}
+
+	failedDomains := cdn.FailedDomains()
+	for domain, err := range failedDomains {
+		level.Error(logging.Logger).Log("msg", "Failed to collect domain", "domain", domain, "err", err)
+	}
+	if gatherErr != nil || len(failedDomains) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"sort"
+	"strings"
+	"upyun-exporter/exporter"
+	"upyun-exporter/logging"
+)
+
+// keyValueFlag 可重复的 key=value 参数, 如 Pushgateway 的 grouping label, OTLP 的 header
+type keyValueFlag map[string]string
+
+func (g keyValueFlag) String() string {
+	pairs := make([]string, 0, len(g))
+	for key, value := range g {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (g keyValueFlag) Set(value string) error {
+	pair := strings.SplitN(value, "=", 2)
+	if len(pair) != 2 || pair[0] == "" {
+		return fmt.Errorf("invalid value %q, must be key=value", value)
+	}
+	g[pair[0]] = pair[1]
+	return nil
+}
+
+// runPush 执行一次 CdnExporter.Collect 并推送到 Pushgateway, 返回进程退出码,
+// 推送失败或有域名采集失败时返回 1
+func runPush(cdn *exporter.CdnExporter, url string, job string, grouping keyValueFlag) int {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(cdn)
+	pusher := push.New(url, job).Gatherer(registry)
+	for key, value := range grouping {
+		pusher = pusher.Grouping(key, value)
+	}
+	pushErr := pusher.Push()
+	if pushErr != nil {
+		level.Error(logging.Logger).Log("msg", "Failed to push metrics to Pushgateway", "url", url, "job", job, "err", pushErr)
+	} else {
+		level.Info(logging.Logger).Log("msg", "Pushed metrics to Pushgateway", "url", url, "job", job, "grouping", grouping.String())
+	}
+
+	failedDomains := cdn.FailedDomains()
+	for domain, err := range failedDomains {
+		level.Error(logging.Logger).Log("msg", "Failed to collect domain", "domain", domain, "err", err)
+	}
+	if pushErr != nil || len(failedDomains) > 0 {
+		return 1
+	}
+	return 0
+}
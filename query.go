@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"upyun-exporter/exporter"
+	"upyun-exporter/httpRequest"
+	"upyun-exporter/logging"
+)
+
+// parseQueryTime 解析 --from/--to, 支持 RFC3339, "2006-01-02 15:04:05"(--timezone 时区) 以及相对当前时间的时长, 如 30m 表示 30 分钟前
+func parseQueryTime(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05", value, httpRequest.TimeZone())
+}
+
+// queryFlags 查询类子命令共用的参数
+type queryFlags struct {
+	fs          *flag.FlagSet
+	credentials *credentialFlags
+	output      *string
+	domain      *string
+	from        *string
+	to          *string
+}
+
+func newQueryFlags(name string) *queryFlags {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	return &queryFlags{
+		fs:          fs,
+		credentials: registerCredentialFlags(fs),
+		output:      fs.String("output", "table", "输出格式, 可选 table, json"),
+		domain:      fs.String("domain", "", "查询的域名"),
+		from:        fs.String("from", "30m", "开始时间, RFC3339, \"2006-01-02 15:04:05\" 或相对当前时间的时长, 默认与exporter的rangeTime一致"),
+		to:          fs.String("to", "5m", "结束时间, 格式同from, 默认与exporter的delayTime一致"),
+	}
+}
+
+// parse 解析参数并初始化日志及 token
+func (q *queryFlags) parse(args []string, needDomain bool) (*httpRequest.Token, *httpRequest.Token, time.Time, time.Time) {
+	logConfig := registerLogFlags(q.fs)
+	registerTimeZoneFlag(q.fs)
+	_ = q.fs.Parse(args)
+	logging.Init(logConfig)
+	if *q.output != "table" && *q.output != "json" {
+		exitWithError("invalid --output, must be table or json")
+	}
+	if needDomain && *q.domain == "" {
+		exitWithError("--domain is required")
+	}
+	from, err := parseQueryTime(*q.from)
+	if err != nil {
+		exitWithError("invalid --from: " + err.Error())
+	}
+	to, err := parseQueryTime(*q.to)
+	if err != nil {
+		exitWithError("invalid --to: " + err.Error())
+	}
+	if !from.Before(to) {
+		exitWithError("--from must be before --to")
+	}
+	token, bucketToken := q.credentials.load()
+	// 与 exporter 一样对齐到 UpYun 聚合粒度
+	window := httpRequest.Window{Start: from, End: to}.Align(httpRequest.DefaultInterval)
+	return token, bucketToken, window.Start, window.End
+}
+
+func exitWithError(message string) {
+	fmt.Fprintln(os.Stderr, logging.Redact(message))
+	os.Exit(1)
+}
+
+func printJSON(value interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		exitWithError(err.Error())
+	}
+}
+
+// printStatusRate 按状态码排序输出状态码占比
+func printStatusRate(w *tabwriter.Writer, prefix string, statusRate map[string]float64) {
+	statuses := make([]string, 0, len(statusRate))
+	for status := range statusRate {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		fmt.Fprintf(w, "%s%s\t%.3f%%\n", prefix, status, statusRate[status])
+	}
+}
+
+// runDomains 输出 exporter 会采集的空间及域名
+func runDomains(args []string) {
+	q := newQueryFlags("domains")
+	_, bucketToken, _, _ := q.parse(args, false)
+	buckets, apiErr := httpRequest.DoBucketDomainListRequest(bucketToken)
+	if apiErr != nil {
+		exitWithError("failed to get domain list: " + apiErr.Error())
+	}
+	if *q.output == "json" {
+		printJSON(buckets)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "BUCKET\tDOMAIN")
+	for _, bucket := range buckets {
+		if len(bucket.Domains) == 0 {
+			fmt.Fprintf(w, "%s\t-\n", bucket.BucketName)
+		}
+		for _, domain := range bucket.Domains {
+			fmt.Fprintf(w, "%s\t%s\n", bucket.BucketName, domain)
+		}
+	}
+	_ = w.Flush()
+}
+
+// runStats 输出带宽接口的原始数据及 exporter 计算出的请求数和带宽
+func runStats(args []string) {
+	q := newQueryFlags("stats")
+	token, _, from, to := q.parse(args, true)
+	data, err := httpRequest.DoHttpBandWidthRangeRequest(*q.domain, token, from, to)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	// 聚合粒度不是默认值时 exporter 之后的采集会按返回的粒度重新对齐
+	if interval := data.IntervalDuration(); interval != httpRequest.DefaultInterval {
+		window := httpRequest.Window{Start: from, End: to}.Align(interval)
+		from, to = window.Start, window.End
+		data, err = httpRequest.DoHttpBandWidthRangeRequest(*q.domain, token, from, to)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+	}
+	// 与 exporter 一样去掉窗口结束时还不完整的粒度
+	data = data.CompleteBefore(to)
+	stats, ok := exporter.CalculateBandWidthStats(data)
+	if *q.output == "json" {
+		printJSON(map[string]interface{}{
+			"domain":   *q.domain,
+			"from":     from,
+			"to":       to,
+			"data":     data.Data,
+			"interval": data.Interval,
+			"computed": stats,
+			"valid":    ok,
+		})
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tREQS\tBANDWIDTH(bps)\tBYTES")
+	for _, point := range data.Data {
+		fmt.Fprintf(w, "%s\t%.0f\t%.0f\t%.0f\n", httpRequest.FormatTime(time.Unix(int64(point.Time), 0)), point.Reqs, point.Bandwidth, point.Bytes)
+	}
+	fmt.Fprintln(w)
+	if !ok {
+		fmt.Fprintln(w, "no traffic in window, exporter would skip this domain")
+	} else {
+		fmt.Fprintf(w, "upyun_cdn_request_count\t%.3f\n", stats.RequestCount)
+		fmt.Fprintf(w, "upyun_cdn_bandwidth\t%.3f\n", stats.BandWidth)
+	}
+	_ = w.Flush()
+}
+
+// runFlow 输出流量明细接口数据及 exporter 计算出的命中率, 回源带宽及状态码占比
+func runFlow(args []string) {
+	q := newQueryFlags("flow")
+	source := q.fs.String("source", "cdn", "流量来源, 可选 cdn, backsource")
+	token, _, from, to := q.parse(args, true)
+	if *source != "cdn" && *source != "backsource" {
+		exitWithError("invalid --source, must be cdn or backsource")
+	}
+	data, err := httpRequest.DoHttpFlowDetailRangeRequest(*q.domain, token, from, to, *source)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	if len(data) == 0 {
+		exitWithError("no flow detail in window")
+	}
+	var computed interface{}
+	if *source == "cdn" {
+		computed = exporter.CalculateFlowStats(data)
+	} else {
+		computed = exporter.CalculateBackSourceStats(data, httpRequest.DefaultInterval)
+	}
+	if *q.output == "json" {
+		printJSON(map[string]interface{}{
+			"domain":   *q.domain,
+			"source":   *source,
+			"from":     from,
+			"to":       to,
+			"data":     data,
+			"computed": computed,
+		})
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REQS\tHIT\tBYTES\tHIT_BYTES\tBANDWIDTH(bps)")
+	for _, point := range data {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%.0f\n", point.Reqs, point.Hit, point.Bytes, point.HitBytes, point.Bandwidth)
+	}
+	fmt.Fprintln(w)
+	switch stats := computed.(type) {
+	case exporter.FlowStats:
+		fmt.Fprintf(w, "upyun_cdn_hit_rate\t%.3f%%\n", stats.HitRate)
+		fmt.Fprintf(w, "upyun_cdn_flux_hit_rate\t%.3f%%\n", stats.FluxHitRate)
+		printStatusRate(w, "upyun_cdn_status_rate ", stats.StatusRate)
+	case exporter.BackSourceStats:
+		fmt.Fprintf(w, "upyun_backsource_resource_bandwidth\t%.3f\n", stats.BandWidth)
+		fmt.Fprintf(w, "upyun_cdn_resource_request_count\t%.3f\n", stats.RequestCount)
+		printStatusRate(w, "upyun_cdn_backsource_status_rate ", stats.StatusRate)
+	}
+	_ = w.Flush()
+}
+
+// subcommandUsage 子命令列表, 用于 help 输出
+var subcommandUsage = strings.TrimSpace(`
+Subcommands:
+  check     check tokens against each UpYun endpoint used by the exporter
+  domains   list buckets and domains the exporter would collect
+  stats     show bandwidth statistics and computed request count/bandwidth for a domain
+  flow      show flow detail and computed hit/status rates for a domain
+  export    export daily traffic totals per domain for a date range as CSV
+`)
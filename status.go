@@ -0,0 +1,184 @@
+package main
+
+import (
+	"flag"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"upyun-exporter/exporter"
+	"upyun-exporter/logging"
+)
+
+// secretFlags 在配置页中隐藏值的参数
+var secretFlags = map[string]bool{
+	"token":               true,
+	"bucket_token":        true,
+	"oauth.password":      true,
+	"oauth.client_secret": true,
+	"sink.influxdb.token": true,
+	"otlp.header":         true,
+}
+
+// urlFlags 值为 URL 的参数, 配置页中隐藏其中的用户名密码及认证相关的 query 参数
+var urlFlags = map[string]bool{
+	"sink.influxdb.url": true,
+	"push.url":          true,
+	"otlp.endpoint":     true,
+}
+
+// credentialParams 视为密钥的 query 参数, 如 InfluxDB 1.x 的 u/p
+var credentialParams = map[string]bool{
+	"u":            true,
+	"p":            true,
+	"user":         true,
+	"username":     true,
+	"password":     true,
+	"token":        true,
+	"access_token": true,
+	"api_key":      true,
+	"apikey":       true,
+	"secret":       true,
+}
+
+// maskURL 隐藏 URL 中的 userinfo 及认证相关的 query 参数, 不带 scheme 的值(如 user:pass@host:port)按 host 解析
+func maskURL(value string) string {
+	raw := value
+	schemeless := !strings.Contains(value, "://")
+	if schemeless {
+		raw = "//" + value
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return value
+	}
+	userinfo := ""
+	if u.User != nil {
+		userinfo = "<secret>@"
+		u.User = nil
+	}
+	if u.RawQuery != "" {
+		params := strings.Split(u.RawQuery, "&")
+		for i, param := range params {
+			key, _, found := strings.Cut(param, "=")
+			name, err := url.QueryUnescape(key)
+			if err != nil {
+				name = key
+			}
+			if found && credentialParams[strings.ToLower(name)] {
+				params[i] = key + "=<secret>"
+			}
+		}
+		u.RawQuery = strings.Join(params, "&")
+	}
+	masked := u.String()
+	if userinfo != "" {
+		masked = strings.Replace(masked, "//", "//"+userinfo, 1)
+	}
+	if schemeless {
+		masked = strings.TrimPrefix(masked, "//")
+	}
+	return masked
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"formatTime": func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.Format(time.RFC3339)
+	},
+}).Parse(`<html>
+<head><title>UPYUN CDN Exporter</title></head>
+<body>
+<h1>Upyun cdn exporter</h1>
+<p><a href='{{.MetricsPath}}'>Metrics</a> | <a href='/config'>Configuration</a> | <a href='/api/v1/domains'>API</a></p>
+<table border='1' cellpadding='4' cellspacing='0'>
+<tr><th>Domain</th><th>Bucket</th><th>Last success</th><th>Last error</th><th>Bandwidth (Mbps)</th><th>Hit rate (%)</th></tr>
+{{range .Domains}}<tr>
+<td><a href='/api/v1/domains/{{.Domain}}'>{{.Domain}}</a></td>
+<td>{{.Bucket}}</td>
+<td>{{formatTime .LastSuccess}}</td>
+<td>{{if .LastError}}{{.LastError}}{{else}}-{{end}}</td>
+<td>{{if .BandWidth}}{{printf "%.3f" .BandWidth.BandWidth}}{{else}}-{{end}}</td>
+<td>{{if .Flow}}{{printf "%.2f" .Flow.HitRate}}{{else}}-{{end}}</td>
+</tr>
+{{else}}<tr><td colspan='6'>No domains discovered yet</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+var configTemplate = template.Must(template.New("config").Parse(`<html>
+<head><title>UPYUN CDN Exporter Configuration</title></head>
+<body>
+<h1>Configuration</h1>
+<p><a href='/'>Status</a></p>
+<table border='1' cellpadding='4' cellspacing='0'>
+<tr><th>Flag</th><th>Value</th><th>Default</th></tr>
+{{range .}}<tr><td>{{.Name}}</td><td>{{.Value}}</td><td>{{.Default}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type configEntry struct {
+	Name    string
+	Value   string
+	Default string
+}
+
+// effectiveConfig 返回所有参数的当前值, 密钥类参数只显示是否已设置, URL 类参数隐藏其中的认证信息
+func effectiveConfig(fs *flag.FlagSet) []configEntry {
+	var entries []configEntry
+	fs.VisitAll(func(f *flag.Flag) {
+		value, defValue := f.Value.String(), f.DefValue
+		if urlFlags[f.Name] {
+			value, defValue = maskURL(value), maskURL(defValue)
+		}
+		if secretFlags[f.Name] {
+			if value != "" {
+				value = "<secret>"
+			}
+			if defValue != "" {
+				defValue = "<secret>"
+			}
+		}
+		entries = append(entries, configEntry{
+			Name:    f.Name,
+			Value:   logging.Redact(value),
+			Default: logging.Redact(defValue),
+		})
+	})
+	return entries
+}
+
+// registerStatusPages 注册 / 状态页及 /config 配置页
+func registerStatusPages(store *exporter.SnapshotStore, metricsPath string) {
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		domainList := DomainList()
+		domains := make([]exporter.DomainStatus, 0, len(domainList))
+		for _, domain := range domainList {
+			domains = append(domains, domainStatus(store, domain))
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := statusTemplate.Execute(w, struct {
+			MetricsPath string
+			Domains     []exporter.DomainStatus
+		}{metricsPath, domains})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	http.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := configTemplate.Execute(w, effectiveConfig(flag.CommandLine)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
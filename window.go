@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+	"upyun-exporter/exporter"
+	"upyun-exporter/httpRequest"
+)
+
+// windowFlags cdn 指标查询窗口参数, 未设置 window.length/window.lag 时由旧的 rangeTime/delayTime 换算
+type windowFlags struct {
+	fs              *flag.FlagSet
+	rangeTime       *int64
+	delayTime       *int64
+	length          *time.Duration
+	lag             *time.Duration
+	lengthOverrides keyValueFlag
+	lagOverrides    keyValueFlag
+}
+
+func registerWindowFlags(fs *flag.FlagSet) *windowFlags {
+	w := &windowFlags{
+		fs:              fs,
+		delayTime:       fs.Int64("delayTime", 300, "已废弃, 使用window.lag. 结束时间=now-delayTime秒"),
+		rangeTime:       fs.Int64("rangeTime", 1800, "已废弃, 使用window.length. 开始时间=now-rangeTime秒, 窗口长度为rangeTime-delayTime, 必须大于delayTime"),
+		length:          fs.Duration("window.length", 25*time.Minute, "cdn指标查询窗口长度, 窗口为[now-lag-length, now-lag]"),
+		lag:             fs.Duration("window.lag", 5*time.Minute, "cdn指标查询窗口结束时间距当前时间的延迟"),
+		lengthOverrides: keyValueFlag{},
+		lagOverrides:    keyValueFlag{},
+	}
+	fs.Var(w.lengthOverrides, "window.length.domain", "单个域名的查询窗口长度, 格式为domain=duration, 可重复")
+	fs.Var(w.lagOverrides, "window.lag.domain", "单个域名的查询窗口延迟, 格式为domain=duration, 可重复")
+	return w
+}
+
+// config 返回校验后的窗口配置, 域名只覆盖了长度或延迟之一时另一项使用配置文件规则或默认值
+func (w *windowFlags) config() (exporter.WindowConfig, error) {
+	set := make(map[string]bool)
+	w.fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	config := exporter.WindowConfig{
+		Default: httpRequest.WindowSpec{Length: *w.length, Lag: *w.lag},
+		Lengths: make(map[string]time.Duration),
+		Lags:    make(map[string]time.Duration),
+	}
+	if !set["window.length"] && !set["window.lag"] && (set["rangeTime"] || set["delayTime"]) {
+		if *w.rangeTime <= *w.delayTime {
+			return config, fmt.Errorf("rangeTime (%d) must be greater than delayTime (%d)", *w.rangeTime, *w.delayTime)
+		}
+		config.Default = httpRequest.WindowSpec{
+			Length: time.Duration(*w.rangeTime-*w.delayTime) * time.Second,
+			Lag:    time.Duration(*w.delayTime) * time.Second,
+		}
+	}
+	if err := config.Default.Validate(); err != nil {
+		return config, err
+	}
+
+	for domain, value := range w.lengthOverrides {
+		length, err := time.ParseDuration(value)
+		if err != nil {
+			return config, fmt.Errorf("invalid window.length.domain for %s: %v", domain, err)
+		}
+		if length <= 0 {
+			return config, fmt.Errorf("invalid window.length.domain for %s: window length must be positive, got %s", domain, length)
+		}
+		config.Lengths[domain] = length
+	}
+	for domain, value := range w.lagOverrides {
+		lag, err := time.ParseDuration(value)
+		if err != nil {
+			return config, fmt.Errorf("invalid window.lag.domain for %s: %v", domain, err)
+		}
+		if lag < 0 {
+			return config, fmt.Errorf("invalid window.lag.domain for %s: window lag must not be negative, got %s", domain, lag)
+		}
+		config.Lags[domain] = lag
+	}
+	return config, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"testing"
+	"time"
+	"upyun-exporter/httpRequest"
+)
+
+func TestWindowFlagsConfig(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    httpRequest.WindowSpec
+		lengths map[string]time.Duration
+		lags    map[string]time.Duration
+		wantErr bool
+	}{
+		{args: nil, want: httpRequest.WindowSpec{Length: 25 * time.Minute, Lag: 5 * time.Minute}},
+		// 旧参数只在未设置 window.* 时换算
+		{args: []string{"-rangeTime=3600", "-delayTime=600"}, want: httpRequest.WindowSpec{Length: 50 * time.Minute, Lag: 10 * time.Minute}},
+		{args: []string{"-rangeTime=3600"}, want: httpRequest.WindowSpec{Length: 55 * time.Minute, Lag: 5 * time.Minute}},
+		{args: []string{"-rangeTime=3600", "-window.lag=1m"}, want: httpRequest.WindowSpec{Length: 25 * time.Minute, Lag: time.Minute}},
+		{args: []string{"-delayTime=600", "-window.length=10m"}, want: httpRequest.WindowSpec{Length: 10 * time.Minute, Lag: 5 * time.Minute}},
+		{args: []string{"-rangeTime=300", "-delayTime=300"}, wantErr: true},
+		{args: []string{"-rangeTime=100"}, wantErr: true},
+		// rangeTime 不大于 delayTime 但设置了 window.* 时旧参数被忽略
+		{args: []string{"-rangeTime=100", "-window.length=10m"}, want: httpRequest.WindowSpec{Length: 10 * time.Minute, Lag: 5 * time.Minute}},
+		{args: []string{"-window.lag=-1m"}, wantErr: true},
+		{args: []string{"-window.length=0s"}, wantErr: true},
+		{
+			args:    []string{"-window.length.domain=a.com=10m", "-window.lag.domain=b.com=0s"},
+			want:    httpRequest.WindowSpec{Length: 25 * time.Minute, Lag: 5 * time.Minute},
+			lengths: map[string]time.Duration{"a.com": 10 * time.Minute},
+			lags:    map[string]time.Duration{"b.com": 0},
+		},
+		{args: []string{"-window.length.domain=a.com=10"}, wantErr: true},
+		{args: []string{"-window.length.domain=a.com=0s"}, wantErr: true},
+		{args: []string{"-window.lag.domain=a.com=-1m"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		window := registerWindowFlags(fs)
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatalf("Parse(%q) error = %v", tt.args, err)
+		}
+		got, err := window.config()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("config(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if got.Default != tt.want {
+			t.Errorf("config(%q).Default = %+v, want %+v", tt.args, got.Default, tt.want)
+		}
+		if !equalDurations(got.Lengths, tt.lengths) || !equalDurations(got.Lags, tt.lags) {
+			t.Errorf("config(%q) = {%v, %v}, want {%v, %v}", tt.args, got.Lengths, got.Lags, tt.lengths, tt.lags)
+		}
+	}
+}
+
+func equalDurations(got, want map[string]time.Duration) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for key, value := range want {
+		if v, ok := got[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}